@@ -0,0 +1,334 @@
+package compressutil
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DefaultLevel selects the default compression level of a codec.
+const DefaultLevel = flate.DefaultCompression
+
+// Codec streams content in one Content-Encoding.
+// Readers and writers must be closed to release them, as they may be reused.
+type Codec interface {
+	// NewReader decode content read from source.
+	NewReader(source io.Reader) (io.ReadCloser, error)
+	// NewWriter encode content to target at level. Closing the writer flushes it without closing target.
+	NewWriter(target io.Writer, level int) (io.WriteCloser, error)
+}
+
+// registry of the codecs available by encoding name, consulted by everything handling Content-Encoding.
+var registry = struct {
+	lock   sync.RWMutex
+	codecs map[string]Codec
+	names  []string
+}{
+	codecs: map[string]Codec{
+		Gzip:    newGzipCodec(),
+		Deflate: newDeflateCodec(),
+	},
+	names: []string{Gzip, Deflate},
+}
+
+// Register make codec available for the Content-Encoding name, replacing any codec already registered.
+// Encodings are preferred in the order they were first registered when a client accepts any of them.
+func Register(name string, codec Codec) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, ok := registry.codecs[name]; !ok {
+		registry.names = append(registry.names, name)
+	}
+
+	registry.codecs[name] = codec
+}
+
+// rawDeflate the Codec for DeflateRaw, which is not registered as it is never negotiated.
+var rawDeflate = newRawDeflateCodec()
+
+// unregister remove the codec registered for name, restoring the registry for tests.
+func unregister(name string) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, ok := registry.codecs[name]; !ok {
+		return
+	}
+
+	delete(registry.codecs, name)
+
+	for i, registered := range registry.names {
+		if registered == name {
+			registry.names = append(registry.names[:i], registry.names[i+1:]...)
+
+			break
+		}
+	}
+}
+
+// Lookup find the Codec registered for encoding.
+func Lookup(encoding string) (Codec, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	codec, ok := registry.codecs[encoding]
+
+	return codec, ok
+}
+
+// lookupCodec find the Codec for encoding, including DeflateRaw which is only reported by DecodeStack.
+func lookupCodec(encoding string) (Codec, bool) {
+	if encoding == DeflateRaw {
+		return rawDeflate, true
+	}
+
+	return Lookup(encoding)
+}
+
+// Encodings list the registered encodings in order of preference.
+func Encodings() []string {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	return append([]string(nil), registry.names...)
+}
+
+// CheckLevel return an error when level is not valid for encoding.
+func CheckLevel(encoding string, level int) error {
+	codec, ok := Lookup(encoding)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnsupportedEncoding, encoding)
+	}
+
+	writer, err := codec.NewWriter(io.Discard, level)
+	if err != nil {
+		return fmt.Errorf("invalid %s compression level %d: %w", encoding, level, err)
+	}
+
+	return writer.Close()
+}
+
+// levelPools hold a sync.Pool of writers for every compression level that was used.
+type levelPools struct {
+	lock  sync.Mutex
+	pools map[int]*sync.Pool
+}
+
+func (levels *levelPools) get(level int) *sync.Pool {
+	levels.lock.Lock()
+	defer levels.lock.Unlock()
+
+	if levels.pools == nil {
+		levels.pools = make(map[int]*sync.Pool)
+	}
+
+	pool, ok := levels.pools[level]
+	if !ok {
+		pool = &sync.Pool{}
+		levels.pools[level] = pool
+	}
+
+	return pool
+}
+
+// gzipCodec a Codec for gzip reusing readers and writers.
+type gzipCodec struct {
+	readers sync.Pool
+	writers levelPools
+}
+
+func newGzipCodec() *gzipCodec {
+	return &gzipCodec{}
+}
+
+func (codec *gzipCodec) NewReader(source io.Reader) (io.ReadCloser, error) {
+	if reader, ok := codec.readers.Get().(*gzip.Reader); ok {
+		if err := reader.Reset(source); err != nil {
+			codec.readers.Put(reader)
+
+			return nil, err
+		}
+
+		return &pooledReader{ReadCloser: reader, release: func() { codec.readers.Put(reader) }}, nil
+	}
+
+	reader, err := gzip.NewReader(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pooledReader{ReadCloser: reader, release: func() { codec.readers.Put(reader) }}, nil
+}
+
+func (codec *gzipCodec) NewWriter(target io.Writer, level int) (io.WriteCloser, error) {
+	pool := codec.writers.get(level)
+
+	writer, ok := pool.Get().(*gzip.Writer)
+	if ok {
+		writer.Reset(target)
+	} else {
+		var err error
+		if writer, err = gzip.NewWriterLevel(target, level); err != nil {
+			return nil, err
+		}
+	}
+
+	return &pooledWriter{WriteCloser: writer, release: func() { pool.Put(writer) }}, nil
+}
+
+// deflateCodec a Codec for deflate writing zlib wrapped streams.
+// Readers detect the zlib header and also accept raw DEFLATE as sent by some servers.
+type deflateCodec struct {
+	readers sync.Pool
+	writers levelPools
+}
+
+func newDeflateCodec() *deflateCodec {
+	return &deflateCodec{}
+}
+
+func (codec *deflateCodec) NewReader(source io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(source)
+
+	// A short or missing header is left for the raw reader to report.
+	header, _ := buffered.Peek(zlibHeaderSize)
+	if !isZlib(header) {
+		return rawDeflate.NewReader(buffered)
+	}
+
+	if reader, ok := codec.readers.Get().(io.ReadCloser); ok {
+		if err := reader.(zlib.Resetter).Reset(buffered, nil); err != nil {
+			codec.readers.Put(reader)
+
+			return nil, err
+		}
+
+		return &pooledReader{ReadCloser: reader, release: func() { codec.readers.Put(reader) }}, nil
+	}
+
+	reader, err := zlib.NewReader(buffered)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pooledReader{ReadCloser: reader, release: func() { codec.readers.Put(reader) }}, nil
+}
+
+func (codec *deflateCodec) NewWriter(target io.Writer, level int) (io.WriteCloser, error) {
+	pool := codec.writers.get(level)
+
+	writer, ok := pool.Get().(*zlib.Writer)
+	if ok {
+		writer.Reset(target)
+	} else {
+		var err error
+		if writer, err = zlib.NewWriterLevel(target, level); err != nil {
+			return nil, err
+		}
+	}
+
+	return &pooledWriter{WriteCloser: writer, release: func() { pool.Put(writer) }}, nil
+}
+
+// zlibHeaderSize the bytes needed to detect a zlib stream.
+const zlibHeaderSize = 2
+
+// isZlib determine if header starts a zlib stream: DEFLATE with a window of at most 32KiB,
+// no preset dictionary and a valid header checksum.
+func isZlib(header []byte) bool {
+	if len(header) < zlibHeaderSize {
+		return false
+	}
+
+	const (
+		methodDeflate   = 8
+		maxWindowBits   = 7
+		presetDict      = 0x20
+		headerCheckBase = 31
+	)
+
+	cmf, flg := header[0], header[1]
+
+	return cmf&0x0f == methodDeflate &&
+		cmf>>4 <= maxWindowBits &&
+		flg&presetDict == 0 &&
+		(uint16(cmf)<<8|uint16(flg))%headerCheckBase == 0
+}
+
+// rawDeflateCodec a Codec for raw DEFLATE reusing readers and writers.
+type rawDeflateCodec struct {
+	readers sync.Pool
+	writers levelPools
+}
+
+func newRawDeflateCodec() *rawDeflateCodec {
+	return &rawDeflateCodec{}
+}
+
+func (codec *rawDeflateCodec) NewReader(source io.Reader) (io.ReadCloser, error) {
+	reader, ok := codec.readers.Get().(io.ReadCloser)
+	if ok {
+		if err := reader.(flate.Resetter).Reset(source, nil); err != nil {
+			return nil, err
+		}
+	} else {
+		reader = flate.NewReader(source)
+	}
+
+	return &pooledReader{ReadCloser: reader, release: func() { codec.readers.Put(reader) }}, nil
+}
+
+func (codec *rawDeflateCodec) NewWriter(target io.Writer, level int) (io.WriteCloser, error) {
+	pool := codec.writers.get(level)
+
+	writer, ok := pool.Get().(*flate.Writer)
+	if ok {
+		writer.Reset(target)
+	} else {
+		var err error
+		if writer, err = flate.NewWriter(target, level); err != nil {
+			return nil, err
+		}
+	}
+
+	return &pooledWriter{WriteCloser: writer, release: func() { pool.Put(writer) }}, nil
+}
+
+// pooledReader return the reader to its pool once closed.
+type pooledReader struct {
+	io.ReadCloser
+
+	release func()
+}
+
+func (reader *pooledReader) Close() error {
+	err := reader.ReadCloser.Close()
+	if reader.release != nil {
+		reader.release()
+		reader.release = nil
+	}
+
+	return err
+}
+
+// pooledWriter return the writer to its pool once closed.
+type pooledWriter struct {
+	io.WriteCloser
+
+	release func()
+}
+
+func (writer *pooledWriter) Close() error {
+	err := writer.WriteCloser.Close()
+	if writer.release != nil {
+		writer.release()
+		writer.release = nil
+	}
+
+	return err
+}
@@ -5,6 +5,9 @@ const (
 	Gzip string = "gzip"
 	// Deflate compression algorithm string.
 	Deflate string = "deflate"
+	// DeflateRaw names raw DEFLATE content received as Deflate without the zlib wrapper.
+	// It selects the codec writing content in that form and is never sent in a header.
+	DeflateRaw string = "deflate-raw"
 	// Identity compression algorithm string.
 	Identity string = "identity"
 )
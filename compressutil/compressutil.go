@@ -3,93 +3,185 @@ package compressutil
 
 import (
 	"bytes"
-	"compress/flate"
-	"compress/gzip"
+	"fmt"
 	"io"
-	"log"
+	"strings"
+	"sync"
 )
 
-// ReaderError for notating that an error occurred while reading compressed data.
-type ReaderError struct {
-	error
-
-	cause error
+// Limits bound the size of decoded content. A zero value disables a limit.
+type Limits struct {
+	// MaxSize in bytes of decoded content.
+	MaxSize int64 `json:"maxSize,omitempty" yaml:"maxSize,omitempty" toml:"maxSize,omitempty"`
+	// MaxRatio of decoded to encoded size.
+	MaxRatio float64 `json:"maxRatio,omitempty" yaml:"maxRatio,omitempty" toml:"maxRatio,omitempty"`
 }
 
 // Decode data in a bytes.Reader based on supplied encoding.
+// Identity content is returned unchanged. Failures, including unknown encodings, are returned as a *ReaderError.
 func Decode(byteReader *bytes.Buffer, encoding string) ([]byte, error) {
-	reader, err := getRawReader(byteReader, encoding)
+	return DecodeWithLimits(byteReader, encoding, Limits{})
+}
+
+// DecodeWithLimits decode like Decode, stopping with an error matching ErrSizeLimit
+// as soon as the decoded content exceeds limits. Limits only apply to compressed encodings.
+func DecodeWithLimits(byteReader *bytes.Buffer, encoding string, limits Limits) ([]byte, error) {
+	codec, ok := lookupCodec(encoding)
+	if !ok {
+		if !isIdentity(encoding) {
+			return nil, unsupportedReaderError(encoding)
+		}
+
+		// Content that is not compressed is already held in memory and cannot expand.
+		return io.ReadAll(byteReader)
+	}
+
+	encodedSize := int64(byteReader.Len())
+
+	reader, err := codec.NewReader(byteReader)
+	if err != nil {
+		return nil, newReaderError(encoding, err)
+	}
+
+	defer func() { _ = reader.Close() }()
+
+	var source io.Reader = reader
+	if limit := limits.maxDecodedSize(encodedSize); limit > 0 {
+		source = &limitedReader{reader: reader, limit: limit}
+	}
+
+	data, err := io.ReadAll(source)
 	if err != nil {
-		return nil, &ReaderError{
-			error: err,
-			cause: err,
+		return nil, newReaderError(encoding, err)
+	}
+
+	return data, nil
+}
+
+// ParseEncodings split a Content-Encoding header into its codings in the order they were applied.
+// Codings are lowercased and identity is dropped, so content without encoding gives an empty list.
+func ParseEncodings(header string) []string {
+	var result []string
+
+	for _, coding := range strings.Split(header, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "" && coding != Identity {
+			result = append(result, coding)
 		}
 	}
 
-	return io.ReadAll(reader)
+	return result
 }
 
-func getRawReader(byteReader *bytes.Buffer, encoding string) (io.Reader, error) {
-	switch encoding {
-	case Gzip:
-		return gzip.NewReader(byteReader)
+// DecodeStack decode content that had every coding of encodings applied in order, undoing the last one first.
+// Limits apply to the total expansion from the encoded content, not to each coding.
+// The codings found are returned for encoding the content again, with DeflateRaw for raw DEFLATE sent as Deflate.
+func DecodeStack(byteReader *bytes.Buffer, encodings []string, limits Limits) ([]byte, []string, error) {
+	found := append([]string(nil), encodings...)
 
-	case Deflate:
-		return flate.NewReader(byteReader), nil
+	if len(encodings) == 0 {
+		data, err := io.ReadAll(byteReader)
 
-	default:
-		return byteReader, nil
+		return data, found, err
 	}
-}
 
-// Encode data in a []byte based on supplied encoding.
-func Encode(data []byte, encoding string) ([]byte, error) {
-	switch encoding {
-	case Gzip:
-		return compressWithGzip(data)
+	layerLimits := Limits{MaxSize: limits.maxDecodedSize(int64(byteReader.Len()))}
 
-	case Deflate:
-		return compressWithZlib(data)
+	for i := len(encodings) - 1; i >= 0; i-- {
+		if encodings[i] == Deflate && !isZlib(byteReader.Bytes()) {
+			found[i] = DeflateRaw
+		}
 
-	default:
-		return data, nil
+		data, err := DecodeWithLimits(byteReader, found[i], layerLimits)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		byteReader = bytes.NewBuffer(data)
 	}
+
+	return byteReader.Bytes(), found, nil
 }
 
-func compressWithGzip(bodyBytes []byte) ([]byte, error) {
-	var buf bytes.Buffer
-	gzipWriter := gzip.NewWriter(&buf)
+// maxDecodedSize the smallest of MaxSize and MaxRatio times encodedSize, or 0 when unlimited.
+func (limits Limits) maxDecodedSize(encodedSize int64) int64 {
+	limit := limits.MaxSize
+
+	if limits.MaxRatio > 0 {
+		ratioLimit := int64(limits.MaxRatio * float64(encodedSize))
+		if limit <= 0 || ratioLimit < limit {
+			limit = ratioLimit
+		}
+	}
+
+	return limit
+}
 
-	if _, err := gzipWriter.Write(bodyBytes); err != nil {
-		log.Printf("unable to recompress rewrited body: %v", err)
+// limitedReader fails once more than limit bytes have been read, unlike io.LimitReader which stops silently.
+type limitedReader struct {
+	reader io.Reader
+	limit  int64
+	read   int64
+}
 
-		return nil, err
+func (limited *limitedReader) Read(data []byte) (int, error) {
+	// Read at most one byte past the limit, enough to know it was exceeded.
+	if remaining := limited.limit - limited.read + 1; int64(len(data)) > remaining {
+		data = data[:remaining]
 	}
 
-	if err := gzipWriter.Close(); err != nil {
-		log.Printf("unable to close gzip writer: %v", err)
+	count, err := limited.reader.Read(data)
+	limited.read += int64(count)
 
-		return nil, err
+	if limited.read > limited.limit {
+		return count, fmt.Errorf("%w: more than %d bytes", ErrSizeLimit, limited.limit)
 	}
 
-	return buf.Bytes(), nil
+	return count, err
 }
 
-func compressWithZlib(bodyBytes []byte) ([]byte, error) {
-	var buf bytes.Buffer
-	zlibWriter, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+// buffers reused while encoding.
+var buffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
 
-	if _, err := zlibWriter.Write(bodyBytes); err != nil {
-		log.Printf("unable to recompress rewrited body: %v", err)
+// Encode data in a []byte based on supplied encoding.
+// Identity content is returned unchanged. Failures, including unknown encodings, are returned as a *WriterError.
+func Encode(data []byte, encoding string) ([]byte, error) {
+	return EncodeLevel(data, encoding, DefaultLevel)
+}
+
+// EncodeLevel encode like Encode using the compression level of the codec.
+func EncodeLevel(data []byte, encoding string, level int) ([]byte, error) {
+	codec, ok := lookupCodec(encoding)
+	if !ok {
+		if !isIdentity(encoding) {
+			return nil, &WriterError{Encoding: encoding, cause: ErrUnsupportedEncoding}
+		}
 
-		return nil, err
+		return data, nil
 	}
 
-	if err := zlibWriter.Close(); err != nil {
-		log.Printf("unable to close zlib writer: %v", err)
+	buffer, _ := buffers.Get().(*bytes.Buffer)
+	buffer.Reset()
+
+	defer buffers.Put(buffer)
+
+	writer, err := codec.NewWriter(buffer, level)
+	if err != nil {
+		return nil, &WriterError{Encoding: encoding, cause: err}
+	}
+
+	if _, err := writer.Write(data); err != nil {
+		_ = writer.Close()
+
+		return nil, &WriterError{Encoding: encoding, cause: err}
+	}
 
-		return nil, err
+	if err := writer.Close(); err != nil {
+		return nil, &WriterError{Encoding: encoding, cause: err}
 	}
 
-	return buf.Bytes(), nil
+	// The buffer is reused so the result is copied out.
+	return append([]byte(nil), buffer.Bytes()...), nil
 }
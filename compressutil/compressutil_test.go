@@ -2,6 +2,13 @@ package compressutil_test
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
 	"testing"
 
 	"github.com/packruler/rewrite-body/compressutil"
@@ -16,69 +23,74 @@ type TestStruct struct {
 }
 
 func TestEncode(t *testing.T) {
-	var (
-		deflatedBytes = []byte{
-			74, 203, 207, 87, 200, 44, 86, 40, 201, 72, 85,
-			200, 75, 45, 87, 72, 74, 44, 2, 4, 0, 0, 255, 255,
-		}
-		gzippedBytes = []byte{
-			31, 139, 8, 0, 0, 0, 0, 0, 0, 255, 74, 203, 207, 87, 200, 44, 86, 40, 201, 72, 85,
-			200, 75, 45, 87, 72, 74, 44, 2, 4, 0, 0, 255, 255, 251, 28, 166, 187, 18, 0, 0, 0,
-		}
-		normalBytes = []byte("foo is the new bar")
-	)
+	normalBytes := []byte("foo is the new bar")
 
-	tests := []TestStruct{
+	tests := []struct {
+		desc      string
+		encoding  string
+		expHeader []byte
+		decode    func(io.Reader) (io.Reader, error)
+	}{
 		{
-			desc:        "should support identity",
-			input:       normalBytes,
-			expected:    normalBytes,
-			encoding:    compressutil.Identity,
-			shouldMatch: true,
+			desc:     "should support identity",
+			encoding: compressutil.Identity,
 		},
 		{
-			desc:        "should support gzip",
-			input:       normalBytes,
-			expected:    gzippedBytes,
-			encoding:    compressutil.Gzip,
-			shouldMatch: false,
+			desc:      "should support gzip",
+			encoding:  compressutil.Gzip,
+			expHeader: []byte{31, 139},
+			decode:    func(source io.Reader) (io.Reader, error) { return gzip.NewReader(source) },
 		},
 		{
-			desc:        "should support deflate",
-			input:       normalBytes,
-			expected:    deflatedBytes,
-			encoding:    compressutil.Deflate,
-			shouldMatch: false,
+			desc:      "should write zlib for deflate",
+			encoding:  compressutil.Deflate,
+			expHeader: []byte{0x78},
+			decode:    func(source io.Reader) (io.Reader, error) { return zlib.NewReader(source) },
 		},
 		{
-			desc:        "should NOT support brotli",
-			input:       normalBytes,
-			expected:    normalBytes,
-			encoding:    "br",
-			shouldMatch: true,
+			desc:     "should write raw deflate",
+			encoding: compressutil.DeflateRaw,
+			decode:   func(source io.Reader) (io.Reader, error) { return flate.NewReader(source), nil },
 		},
 	}
 
+	var writerError *compressutil.WriterError
+	if _, err := compressutil.Encode(normalBytes, "br"); !errors.Is(err, compressutil.ErrUnsupportedEncoding) || !errors.As(err, &writerError) {
+		t.Errorf("got error %v for br, want a WriterError matching %v", err, compressutil.ErrUnsupportedEncoding)
+	}
+
 	for _, test := range tests {
 		t.Run(test.desc, func(t *testing.T) {
-			output, err := compressutil.Encode(test.input, test.encoding)
+			output, err := compressutil.Encode(normalBytes, test.encoding)
 			if err != nil {
-				t.Errorf("unexpected error: %v", err)
+				t.Fatalf("unexpected error: %v", err)
 			}
 
-			isBad := !bytes.Equal(test.expected, output)
+			if test.decode == nil {
+				if !bytes.Equal(output, normalBytes) {
+					t.Errorf("got body: %v\n wanted: %v", output, normalBytes)
+				}
 
-			if isBad {
-				t.Errorf("expected error got body: %v\n wanted: %v", output, test.expected)
+				return
 			}
 
-			if test.shouldMatch {
-				isBad = !bytes.Equal(test.input, output)
-			} else {
-				isBad = bytes.Equal(test.input, output)
+			if !bytes.HasPrefix(output, test.expHeader) {
+				t.Errorf("got body: %v\n wanted prefix: %v", output, test.expHeader)
 			}
-			if isBad {
-				t.Errorf("match error got body: %v\n wanted: %v", output, test.input)
+
+			// Decode with the standard library rather than compressutil to check the format.
+			reader, err := test.decode(bytes.NewReader(output))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(decoded, normalBytes) {
+				t.Errorf("got decoded body: %q", decoded)
 			}
 		})
 	}
@@ -95,6 +107,7 @@ func TestDecode(t *testing.T) {
 			200, 75, 45, 87, 72, 74, 44, 2, 4, 0, 0, 255, 255, 251, 28, 166, 187, 18, 0, 0, 0,
 		}
 		normalBytes = []byte("foo is the new bar")
+		zlibBytes   = zlibString(t, normalBytes)
 	)
 
 	tests := []TestStruct{
@@ -113,18 +126,18 @@ func TestDecode(t *testing.T) {
 			shouldMatch: false,
 		},
 		{
-			desc:        "should support deflate",
+			desc:        "should support raw deflate",
 			input:       deflatedBytes,
 			expected:    normalBytes,
 			encoding:    compressutil.Deflate,
 			shouldMatch: false,
 		},
 		{
-			desc:        "should NOT support brotli",
-			input:       normalBytes,
+			desc:        "should support zlib deflate",
+			input:       zlibBytes,
 			expected:    normalBytes,
-			encoding:    "br",
-			shouldMatch: true,
+			encoding:    compressutil.Deflate,
+			shouldMatch: false,
 		},
 	}
 
@@ -152,3 +165,673 @@ func TestDecode(t *testing.T) {
 		})
 	}
 }
+
+// zlibString compress data with the standard library zlib writer.
+func zlibString(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+
+	writer := zlib.NewWriter(&buffer)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func TestDecodeErrors(t *testing.T) {
+	gzipped, err := compressutil.Encode([]byte("foo is the new bar"), compressutil.Gzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := append([]byte(nil), gzipped...)
+	corrupt[len(corrupt)-5] ^= 0xff
+
+	corruptZlib := zlibString(t, []byte("foo is the new bar"))
+	corruptZlib[len(corruptZlib)-1] ^= 0xff
+
+	tests := []struct {
+		desc     string
+		input    []byte
+		encoding string
+		expErr   error
+	}{
+		{
+			desc:     "should report a truncated gzip stream",
+			input:    gzipped[:len(gzipped)/2],
+			encoding: compressutil.Gzip,
+			expErr:   compressutil.ErrTruncatedStream,
+		},
+		{
+			desc:     "should report a gzip checksum mismatch as corrupt",
+			input:    corrupt,
+			encoding: compressutil.Gzip,
+			expErr:   compressutil.ErrCorruptStream,
+		},
+		{
+			desc:     "should report an invalid gzip header as corrupt",
+			input:    []byte("foo is the new bar"),
+			encoding: compressutil.Gzip,
+			expErr:   compressutil.ErrCorruptStream,
+		},
+		{
+			desc:     "should report a zlib checksum mismatch as corrupt",
+			input:    corruptZlib,
+			encoding: compressutil.Deflate,
+			expErr:   compressutil.ErrCorruptStream,
+		},
+		{
+			desc:     "should report invalid deflate data as corrupt",
+			input:    []byte{0xff, 0xff, 0xff, 0xff},
+			encoding: compressutil.Deflate,
+			expErr:   compressutil.ErrCorruptStream,
+		},
+		{
+			desc:     "should report an unknown encoding as unsupported",
+			input:    []byte("foo is the new bar"),
+			encoding: "br",
+			expErr:   compressutil.ErrUnsupportedEncoding,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := compressutil.Decode(bytes.NewBuffer(test.input), test.encoding)
+			if !errors.Is(err, test.expErr) {
+				t.Fatalf("got error %v, want %v", err, test.expErr)
+			}
+
+			var readerError *compressutil.ReaderError
+			if !errors.As(err, &readerError) || readerError.Encoding != test.encoding {
+				t.Errorf("got error %#v, want a ReaderError for %s", err, test.encoding)
+			}
+
+			if errors.Unwrap(err) == nil {
+				t.Error("expected the reader error to be unwrapped")
+			}
+		})
+	}
+}
+
+func TestCheckEncoding(t *testing.T) {
+	for _, encoding := range []string{compressutil.Gzip, compressutil.Deflate, compressutil.Identity, ""} {
+		if err := compressutil.CheckEncoding(encoding); err != nil {
+			t.Errorf("unexpected error for %q: %v", encoding, err)
+		}
+	}
+
+	for _, encoding := range []string{"br", compressutil.DeflateRaw} {
+		if err := compressutil.CheckEncoding(encoding); !errors.Is(err, compressutil.ErrUnsupportedEncoding) {
+			t.Errorf("got error %v for %q, want %v", err, encoding, compressutil.ErrUnsupportedEncoding)
+		}
+	}
+}
+
+func TestDecodeWithLimits(t *testing.T) {
+	large := bytes.Repeat([]byte("a"), 100000)
+
+	gzipped, err := compressutil.Encode(large, compressutil.Gzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc     string
+		input    []byte
+		encoding string
+		limits   compressutil.Limits
+		expErr   bool
+	}{
+		{
+			desc:     "should decode within the limits",
+			input:    gzipped,
+			encoding: compressutil.Gzip,
+			limits:   compressutil.Limits{MaxSize: int64(len(large)), MaxRatio: 10000},
+		},
+		{
+			desc:     "should stop beyond the maximum size",
+			input:    gzipped,
+			encoding: compressutil.Gzip,
+			limits:   compressutil.Limits{MaxSize: 1000},
+			expErr:   true,
+		},
+		{
+			desc:     "should stop beyond the maximum ratio",
+			input:    gzipped,
+			encoding: compressutil.Gzip,
+			limits:   compressutil.Limits{MaxRatio: 10},
+			expErr:   true,
+		},
+		{
+			desc:     "should ignore negative limits",
+			input:    gzipped,
+			encoding: compressutil.Gzip,
+			limits:   compressutil.Limits{MaxSize: -1, MaxRatio: -1},
+		},
+		{
+			desc:     "should not limit identity content",
+			input:    large,
+			encoding: compressutil.Identity,
+			limits:   compressutil.Limits{MaxSize: 1000, MaxRatio: 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			output, err := compressutil.DecodeWithLimits(bytes.NewBuffer(test.input), test.encoding, test.limits)
+			if test.expErr {
+				if !errors.Is(err, compressutil.ErrSizeLimit) {
+					t.Errorf("got error %v, want %v", err, compressutil.ErrSizeLimit)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !bytes.Equal(output, large) {
+				t.Errorf("got %d bytes, want %d", len(output), len(large))
+			}
+		})
+	}
+}
+
+func TestParseEncodings(t *testing.T) {
+	tests := []struct {
+		desc     string
+		header   string
+		expected []string
+	}{
+		{desc: "empty", header: "", expected: nil},
+		{desc: "identity", header: "identity", expected: nil},
+		{desc: "single", header: "gzip", expected: []string{"gzip"}},
+		{desc: "stacked", header: "deflate, GZIP", expected: []string{"deflate", "gzip"}},
+		{desc: "identity in stack", header: "gzip,identity, ,deflate", expected: []string{"gzip", "deflate"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			encodings := compressutil.ParseEncodings(test.header)
+			if strings.Join(encodings, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("got %v, want %v", encodings, test.expected)
+			}
+		})
+	}
+}
+
+func TestDecodeStack(t *testing.T) {
+	input := bytes.Repeat([]byte("foo is the new bar "), 100)
+
+	deflated, err := compressutil.Encode(input, compressutil.Deflate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stacked, err := compressutil.Encode(deflated, compressutil.Gzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encodings := []string{compressutil.Deflate, compressutil.Gzip}
+
+	decoded, found, err := compressutil.DecodeStack(bytes.NewBuffer(stacked), encodings, compressutil.Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decoded, input) {
+		t.Errorf("got %q", decoded)
+	}
+
+	if strings.Join(found, ",") != "deflate,gzip" {
+		t.Errorf("got codings %v", found)
+	}
+
+	_, _, err = compressutil.DecodeStack(bytes.NewBuffer(stacked), encodings, compressutil.Limits{MaxSize: int64(len(input) - 1)})
+	if !errors.Is(err, compressutil.ErrSizeLimit) {
+		t.Errorf("got error %v, want ErrSizeLimit", err)
+	}
+
+	// The ratio applies to the total expansion, which each coding alone stays below.
+	ratio := float64(len(input)) / float64(len(stacked)) / 2
+
+	_, _, err = compressutil.DecodeStack(bytes.NewBuffer(stacked), encodings, compressutil.Limits{MaxRatio: ratio})
+	if !errors.Is(err, compressutil.ErrSizeLimit) {
+		t.Errorf("got error %v, want ErrSizeLimit", err)
+	}
+
+	decoded, _, err = compressutil.DecodeStack(bytes.NewBufferString("plain"), nil, compressutil.Limits{})
+	if err != nil || string(decoded) != "plain" {
+		t.Errorf("got %q, %v", decoded, err)
+	}
+
+	raw, err := compressutil.Encode(input, compressutil.DeflateRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, found, err = compressutil.DecodeStack(bytes.NewBuffer(raw), []string{compressutil.Deflate}, compressutil.Limits{})
+	if err != nil || !bytes.Equal(decoded, input) {
+		t.Errorf("got %q, %v", decoded, err)
+	}
+
+	if strings.Join(found, ",") != compressutil.DeflateRaw {
+		t.Errorf("got codings %v, want raw deflate", found)
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	input := bytes.Repeat([]byte("foo is the new bar "), 100)
+
+	if _, ok := compressutil.Lookup(compressutil.DeflateRaw); ok {
+		t.Errorf("got a public codec for %s", compressutil.DeflateRaw)
+	}
+
+	for _, encoding := range []string{compressutil.Gzip, compressutil.Deflate} {
+		for _, level := range []int{compressutil.DefaultLevel, 1, 9} {
+			codec, ok := compressutil.Lookup(encoding)
+			if !ok {
+				t.Fatalf("missing codec for %s", encoding)
+			}
+
+			// Run twice so the second round uses pooled readers and writers.
+			for round := 0; round < 2; round++ {
+				var encoded bytes.Buffer
+
+				writer, err := codec.NewWriter(&encoded, level)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				_, _ = writer.Write(input)
+
+				if err := writer.Close(); err != nil {
+					t.Fatal(err)
+				}
+
+				reader, err := codec.NewReader(&encoded)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				decoded, err := io.ReadAll(reader)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				_ = reader.Close()
+
+				if !bytes.Equal(decoded, input) {
+					t.Errorf("%s level %d round %d: got %q", encoding, level, round, decoded)
+				}
+			}
+		}
+	}
+
+	if _, ok := compressutil.Lookup("br"); ok {
+		t.Error("unexpected codec for br")
+	}
+}
+
+// reverseCodec a test Codec storing content reversed.
+type reverseCodec struct{}
+
+func (reverseCodec) NewReader(source io.Reader) (io.ReadCloser, error) {
+	data, err := io.ReadAll(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(reverse(data))), nil
+}
+
+func (reverseCodec) NewWriter(target io.Writer, _ int) (io.WriteCloser, error) {
+	return &reverseWriter{target: target}, nil
+}
+
+type reverseWriter struct {
+	bytes.Buffer
+
+	target io.Writer
+}
+
+func (writer *reverseWriter) Close() error {
+	_, err := writer.target.Write(reverse(writer.Bytes()))
+
+	return err
+}
+
+func reverse(data []byte) []byte {
+	result := make([]byte, len(data))
+	for i, value := range data {
+		result[len(data)-1-i] = value
+	}
+
+	return result
+}
+
+func TestRegister(t *testing.T) {
+	const name = "x-reverse"
+
+	if err := compressutil.CheckEncoding(name); err == nil {
+		t.Fatalf("expected %s to be unsupported before registering", name)
+	}
+
+	registered := compressutil.Encodings()
+
+	compressutil.Register(name, reverseCodec{})
+	compressutil.Register(name, reverseCodec{})
+	t.Cleanup(func() { compressutil.Unregister(name) })
+
+	if err := compressutil.CheckEncoding(name); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	encodings := compressutil.Encodings()
+	expected := append(registered, name)
+
+	if len(encodings) != len(expected) {
+		t.Fatalf("got encodings %v, want %v", encodings, expected)
+	}
+
+	for i := range expected {
+		if encodings[i] != expected[i] {
+			t.Errorf("got encodings %v, want %v", encodings, expected)
+		}
+	}
+
+	encoded, err := compressutil.Encode([]byte("foo bar"), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(encoded) != "rab oof" {
+		t.Errorf("got encoded %q", encoded)
+	}
+
+	decoded, err := compressutil.Decode(bytes.NewBuffer(encoded), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(decoded) != "foo bar" {
+		t.Errorf("got decoded %q", decoded)
+	}
+}
+
+func TestEncodeParallel(t *testing.T) {
+	input := bytes.Repeat([]byte("foo is the new bar "), 200)
+
+	tests := []struct {
+		desc       string
+		encoding   string
+		parallel   compressutil.Parallel
+		expMembers int
+	}{
+		{
+			desc:       "should join compressed blocks into a single gzip member",
+			encoding:   compressutil.Gzip,
+			parallel:   compressutil.Parallel{Threshold: 1, BlockSize: 1000, Workers: 2},
+			expMembers: 1,
+		},
+		{
+			desc:       "should join a short last block",
+			encoding:   compressutil.Gzip,
+			parallel:   compressutil.Parallel{Threshold: 1, BlockSize: 999, Workers: 3},
+			expMembers: 1,
+		},
+		{
+			desc:       "should use a single member below the threshold",
+			encoding:   compressutil.Gzip,
+			parallel:   compressutil.Parallel{Threshold: len(input) + 1, BlockSize: 1000},
+			expMembers: 1,
+		},
+		{
+			desc:       "should use a single member when disabled",
+			encoding:   compressutil.Gzip,
+			parallel:   compressutil.Parallel{BlockSize: 1000},
+			expMembers: 1,
+		},
+		{
+			desc:     "should not split deflate",
+			encoding: compressutil.Deflate,
+			parallel: compressutil.Parallel{Threshold: 1, BlockSize: 1000},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			output, err := compressutil.EncodeParallel(input, test.encoding, compressutil.DefaultLevel, test.parallel)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := compressutil.Decode(bytes.NewBuffer(output), test.encoding)
+			if err != nil || !bytes.Equal(decoded, input) {
+				t.Fatalf("got %d bytes, %v", len(decoded), err)
+			}
+
+			if test.expMembers == 0 {
+				return
+			}
+
+			if members := gzipMembers(t, output); members != test.expMembers {
+				t.Errorf("got %d gzip members, want %d", members, test.expMembers)
+			}
+
+			// Decoders that stop after the first member, such as Chromium's, must still read the whole body.
+			reader, err := gzip.NewReader(bytes.NewReader(output))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reader.Multistream(false)
+
+			first, err := io.ReadAll(reader)
+			if err != nil || !bytes.Equal(first, input) {
+				t.Errorf("got %d bytes in the first member, %v", len(first), err)
+			}
+		})
+	}
+
+	if err := (compressutil.Parallel{Workers: -1}).Check(); err == nil {
+		t.Error("expected an error for negative workers")
+	}
+}
+
+// gzipMembers count the members of a gzip stream.
+func gzipMembers(t *testing.T, data []byte) int {
+	t.Helper()
+
+	source := bytes.NewReader(data)
+
+	reader, err := gzip.NewReader(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members := 0
+
+	for {
+		reader.Multistream(false)
+
+		if _, err := io.Copy(io.Discard, reader); err != nil {
+			t.Fatal(err)
+		}
+
+		members++
+
+		if err := reader.Reset(source); errors.Is(err, io.EOF) {
+			return members
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestThresholds(t *testing.T) {
+	text := bytes.Repeat([]byte("foo is the new bar "), 100)
+
+	random := make([]byte, 4096)
+	_, _ = rand.New(rand.NewSource(1)).Read(random)
+
+	tests := []struct {
+		desc       string
+		thresholds compressutil.Thresholds
+		data       []byte
+		expWorth   bool
+	}{
+		{desc: "zero value compresses tiny content", data: []byte("foo"), expWorth: true},
+		{desc: "skips content below the minimum size", thresholds: compressutil.Thresholds{MinSize: 64}, data: []byte("foo")},
+		{desc: "compresses content above the minimum size", thresholds: compressutil.Thresholds{MinSize: 64}, data: text, expWorth: true},
+		{desc: "skips compressed content", thresholds: compressutil.Thresholds{MinSavings: 0.1}, data: random},
+		{desc: "compresses text", thresholds: compressutil.Thresholds{MinSavings: 0.1}, data: text, expWorth: true},
+		{desc: "compresses compressed content without minSavings", data: random, expWorth: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if worth := test.thresholds.Worth(test.data); worth != test.expWorth {
+				t.Errorf("got worth %v, want %v", worth, test.expWorth)
+			}
+		})
+	}
+
+	thresholds := compressutil.Thresholds{MinSavings: 0.1}
+	if !thresholds.Paid(100, 90) || thresholds.Paid(100, 91) {
+		t.Error("expected compression to pay off when saving at least 10%")
+	}
+
+	if !(compressutil.Thresholds{}).Paid(10, 30) {
+		t.Error("expected the zero value to always pay off")
+	}
+
+	for _, invalid := range []compressutil.Thresholds{{MinSize: -1}, {MinSavings: -0.1}, {MinSavings: 1}} {
+		if err := invalid.Check(); err == nil {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+}
+
+func TestCheckLevel(t *testing.T) {
+	if err := compressutil.CheckLevel(compressutil.Gzip, 9); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := compressutil.CheckLevel(compressutil.Gzip, 42); err == nil {
+		t.Error("expected an error for level 42")
+	}
+
+	if err := compressutil.CheckLevel("br", 1); !errors.Is(err, compressutil.ErrUnsupportedEncoding) {
+		t.Errorf("got error %v, want %v", err, compressutil.ErrUnsupportedEncoding)
+	}
+}
+
+var benchmarkBody = bytes.Repeat([]byte("<p>foo is the new bar</p>\n"), 2000)
+
+func BenchmarkEncodeGzip(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := compressutil.Encode(benchmarkBody, compressutil.Gzip); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkEncodeGzipUnpooled allocates a writer and buffer per call as Encode did before pooling.
+func BenchmarkEncodeGzipUnpooled(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+
+		writer := gzip.NewWriter(&buf)
+		_, _ = writer.Write(benchmarkBody)
+
+		if err := writer.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeDeflate(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := compressutil.Encode(benchmarkBody, compressutil.Deflate); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkEncodeDeflateUnpooled allocates a writer and buffer per call as Encode did before pooling.
+func BenchmarkEncodeDeflateUnpooled(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+
+		writer, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+		_, _ = writer.Write(benchmarkBody)
+
+		if err := writer.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeGzip(b *testing.B) {
+	encoded, _ := compressutil.Encode(benchmarkBody, compressutil.Gzip)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := compressutil.Decode(bytes.NewBuffer(encoded), compressutil.Gzip); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDecodeGzipUnpooled allocates a reader per call as Decode did before pooling.
+func BenchmarkDecodeGzipUnpooled(b *testing.B) {
+	encoded, _ := compressutil.Encode(benchmarkBody, compressutil.Gzip)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		reader, err := gzip.NewReader(bytes.NewBuffer(encoded))
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		if _, err := io.ReadAll(reader); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkEncodeGzipParallel compresses a multi-megabyte body in blocks on every CPU.
+func BenchmarkEncodeGzipParallel(b *testing.B) {
+	body := bytes.Repeat(benchmarkBody, 80)
+	parallel := compressutil.Parallel{Threshold: 1, BlockSize: 256 * 1024}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+
+	for i := 0; i < b.N; i++ {
+		if _, err := compressutil.EncodeParallel(body, compressutil.Gzip, compressutil.DefaultLevel, parallel); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
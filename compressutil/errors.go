@@ -0,0 +1,107 @@
+package compressutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrUnsupportedEncoding the Content-Encoding is not handled by this package.
+	ErrUnsupportedEncoding = errors.New("unsupported encoding")
+	// ErrCorruptStream the compressed data is malformed or fails its checksum.
+	ErrCorruptStream = errors.New("corrupt compressed stream")
+	// ErrTruncatedStream the compressed data ended before the end of the stream.
+	ErrTruncatedStream = errors.New("truncated compressed stream")
+	// ErrSizeLimit decoding the data would exceed the configured size limits.
+	ErrSizeLimit = errors.New("decoded size limit exceeded")
+)
+
+// ReaderError for notating that an error occurred while reading compressed data.
+// It matches one of ErrCorruptStream, ErrTruncatedStream, ErrSizeLimit or ErrUnsupportedEncoding with errors.Is
+// and unwraps to the error returned by the underlying reader.
+type ReaderError struct {
+	error
+
+	// Encoding being decoded when the error occurred.
+	Encoding string
+
+	kind  error
+	cause error
+}
+
+func newReaderError(encoding string, err error) *ReaderError {
+	kind := classifyReadError(err)
+
+	return &ReaderError{
+		error:    fmt.Errorf("unable to decode %s content: %w: %v", encoding, kind, err),
+		Encoding: encoding,
+		kind:     kind,
+		cause:    err,
+	}
+}
+
+// unsupportedReaderError the error for content in an encoding without a codec.
+func unsupportedReaderError(encoding string) *ReaderError {
+	return &ReaderError{
+		error:    fmt.Errorf("unable to decode %s content: %w", encoding, ErrUnsupportedEncoding),
+		Encoding: encoding,
+		kind:     ErrUnsupportedEncoding,
+		cause:    ErrUnsupportedEncoding,
+	}
+}
+
+// Is report if target is the kind of failure of the error.
+func (readerError *ReaderError) Is(target error) bool {
+	return target == readerError.kind
+}
+
+// Unwrap the error returned by the underlying reader.
+func (readerError *ReaderError) Unwrap() error {
+	return readerError.cause
+}
+
+// WriterError for notating that an error occurred while compressing data.
+type WriterError struct {
+	// Encoding being applied when the error occurred.
+	Encoding string
+
+	cause error
+}
+
+func (writerError *WriterError) Error() string {
+	return fmt.Sprintf("unable to encode %s content: %v", writerError.Encoding, writerError.cause)
+}
+
+// Unwrap the error returned by the underlying writer.
+func (writerError *WriterError) Unwrap() error {
+	return writerError.cause
+}
+
+// classifyReadError map a reader error to its kind. Errors other than a size limit or the data ending
+// early, such as gzip.ErrHeader, gzip.ErrChecksum or flate.CorruptInputError, are malformed data.
+func classifyReadError(err error) error {
+	switch {
+	case errors.Is(err, ErrSizeLimit):
+		return ErrSizeLimit
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return ErrTruncatedStream
+	default:
+		return ErrCorruptStream
+	}
+}
+
+// CheckEncoding return an error matching ErrUnsupportedEncoding when encoding is not handled by this package.
+// An empty encoding is treated as Identity.
+func CheckEncoding(encoding string) error {
+	if _, ok := Lookup(encoding); ok || isIdentity(encoding) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %q", ErrUnsupportedEncoding, encoding)
+}
+
+// isIdentity report if encoding leaves content unchanged. An empty encoding is treated as Identity.
+func isIdentity(encoding string) bool {
+	return encoding == Identity || encoding == ""
+}
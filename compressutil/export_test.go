@@ -0,0 +1,4 @@
+package compressutil
+
+// Unregister exposes unregister so tests can remove the codecs they register.
+var Unregister = unregister
@@ -0,0 +1,159 @@
+package compressutil
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"runtime"
+	"sync"
+)
+
+// defaultBlockSize of the blocks compressed in parallel unless configured otherwise.
+const defaultBlockSize = 1024 * 1024
+
+// Parallel configure compressing large gzip content in blocks on several goroutines.
+// Blocks are compressed as raw DEFLATE and joined into a single gzip member, so every decoder reads the whole body.
+type Parallel struct {
+	// Threshold in bytes from which content is compressed in parallel. Zero disables parallel compression.
+	Threshold int `json:"threshold,omitempty" yaml:"threshold,omitempty" toml:"threshold,omitempty"`
+	// BlockSize in bytes of content compressed by each worker. Defaults to 1MiB.
+	BlockSize int `json:"blockSize,omitempty" yaml:"blockSize,omitempty" toml:"blockSize,omitempty"`
+	// Workers compressing blocks at the same time. Defaults to GOMAXPROCS.
+	Workers int `json:"workers,omitempty" yaml:"workers,omitempty" toml:"workers,omitempty"`
+}
+
+// Check return an error when a setting is negative.
+func (parallel Parallel) Check() error {
+	if parallel.Threshold < 0 || parallel.BlockSize < 0 || parallel.Workers < 0 {
+		return fmt.Errorf("invalid parallel compression %+v: settings must not be negative", parallel)
+	}
+
+	return nil
+}
+
+// applies determine if content of size bytes in encoding is compressed in parallel.
+func (parallel Parallel) applies(encoding string, size int) bool {
+	return encoding == Gzip && parallel.Threshold > 0 && size >= parallel.Threshold && size > parallel.blockSize()
+}
+
+func (parallel Parallel) blockSize() int {
+	if parallel.BlockSize > 0 {
+		return parallel.BlockSize
+	}
+
+	return defaultBlockSize
+}
+
+func (parallel Parallel) workers() int {
+	if parallel.Workers > 0 {
+		return parallel.Workers
+	}
+
+	return runtime.GOMAXPROCS(0)
+}
+
+// EncodeParallel encode like EncodeLevel, compressing gzip content of at least parallel.Threshold bytes
+// in blocks on parallel workers. Other content is encoded by EncodeLevel.
+func EncodeParallel(data []byte, encoding string, level int, parallel Parallel) ([]byte, error) {
+	if !parallel.applies(encoding, len(data)) {
+		return EncodeLevel(data, encoding, level)
+	}
+
+	blockSize := parallel.blockSize()
+	blocks := make([][]byte, (len(data)+blockSize-1)/blockSize)
+	errs := make([]error, len(blocks))
+	workers := make(chan struct{}, parallel.workers())
+
+	var group sync.WaitGroup
+
+	for index := range blocks {
+		start := index * blockSize
+
+		end := start + blockSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		dictionaryStart := start - maxDictionary
+		if dictionaryStart < 0 {
+			dictionaryStart = 0
+		}
+
+		group.Add(1)
+		workers <- struct{}{}
+
+		go func(index int, dictionary, block []byte) {
+			defer func() {
+				<-workers
+				group.Done()
+			}()
+
+			blocks[index], errs[index] = deflateBlock(dictionary, block, level, index == len(blocks)-1)
+		}(index, data[dictionaryStart:start], data[start:end])
+	}
+
+	group.Wait()
+
+	var result bytes.Buffer
+
+	result.Write(gzipHeader(level))
+
+	for index, block := range blocks {
+		if errs[index] != nil {
+			return nil, &WriterError{Encoding: encoding, cause: errs[index]}
+		}
+
+		result.Write(block)
+	}
+
+	var trailer [8]byte
+
+	binary.LittleEndian.PutUint32(trailer[:4], crc32.ChecksumIEEE(data))
+	binary.LittleEndian.PutUint32(trailer[4:], uint32(len(data)))
+	result.Write(trailer[:])
+
+	return result.Bytes(), nil
+}
+
+// maxDictionary bytes of preceding content a DEFLATE block may refer back to.
+const maxDictionary = 32 * 1024
+
+// deflateBlock compress block as raw DEFLATE primed with the content preceding it.
+// Blocks other than the last end with a sync flush so they can be concatenated into a single stream.
+func deflateBlock(dictionary, block []byte, level int, last bool) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	writer, err := flate.NewWriterDict(&buffer, level, dictionary)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := writer.Write(block); err != nil {
+		return nil, err
+	}
+
+	if last {
+		err = writer.Close()
+	} else {
+		err = writer.Flush()
+	}
+
+	return buffer.Bytes(), err
+}
+
+// gzipHeader the gzip member header written by compress/gzip for level, without name or modification time.
+func gzipHeader(level int) []byte {
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+
+	switch level {
+	case flate.BestCompression:
+		header[8] = 2
+	case flate.BestSpeed:
+		header[8] = 4
+	default:
+	}
+
+	return header
+}
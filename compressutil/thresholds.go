@@ -0,0 +1,78 @@
+package compressutil
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// entropySample bytes inspected to estimate whether content is compressible.
+	entropySample = 16 * 1024
+	// maxCompressibleEntropy in bits per byte. Compressed, encrypted and random data are close to 8.
+	maxCompressibleEntropy = 7.5
+)
+
+// Thresholds decide when compressing content does not pay off and it is better sent as identity.
+// The zero value always compresses.
+type Thresholds struct {
+	// MinSize in bytes of content worth compressing.
+	MinSize int `json:"minSize,omitempty" yaml:"minSize,omitempty" toml:"minSize,omitempty"`
+	// MinSavings fraction of the size compression must save, such as 0.1.
+	// When set, content that looks incompressible is not compressed at all.
+	MinSavings float64 `json:"minSavings,omitempty" yaml:"minSavings,omitempty" toml:"minSavings,omitempty"`
+}
+
+// Check return an error when a threshold is out of range.
+func (thresholds Thresholds) Check() error {
+	if thresholds.MinSize < 0 || thresholds.MinSavings < 0 || thresholds.MinSavings >= 1 {
+		return fmt.Errorf("invalid compression thresholds %+v: minSize must not be negative and minSavings must be in [0, 1)", thresholds)
+	}
+
+	return nil
+}
+
+// Worth determine before encoding if data is large enough and compressible enough to be compressed.
+func (thresholds Thresholds) Worth(data []byte) bool {
+	if len(data) < thresholds.MinSize {
+		return false
+	}
+
+	return thresholds.MinSavings <= 0 || !Incompressible(data)
+}
+
+// Paid determine after encoding if size bytes compressed to encodedSize saved enough.
+func (thresholds Thresholds) Paid(size, encodedSize int) bool {
+	if thresholds.MinSavings <= 0 {
+		return true
+	}
+
+	return float64(encodedSize) <= float64(size)*(1-thresholds.MinSavings)
+}
+
+// Incompressible estimate from the entropy of a sample whether data is already compressed or random.
+func Incompressible(data []byte) bool {
+	if len(data) > entropySample {
+		data = data[:entropySample]
+	}
+
+	if len(data) == 0 {
+		return false
+	}
+
+	var counts [256]int
+	for _, value := range data {
+		counts[value]++
+	}
+
+	entropy := 0.0
+	size := float64(len(data))
+
+	for _, count := range counts {
+		if count > 0 {
+			probability := float64(count) / size
+			entropy -= probability * math.Log2(probability)
+		}
+	}
+
+	return entropy > maxCompressibleEntropy
+}
@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/packruler/rewrite-body/httputil"
+)
+
+const (
+	defaultAdminPath = "/__rewrite-body/"
+	// recentErrorLimit number of errors kept for the admin endpoint.
+	recentErrorLimit = 20
+)
+
+// defaultAllowedCIDRs only allow the admin and metrics endpoints from loopback addresses.
+var defaultAllowedCIDRs = []string{"127.0.0.0/8", "::1/128"}
+
+// admin serves the introspection endpoint to clients within the allowed networks.
+type admin struct {
+	path    string
+	allowed clientNetworks
+	config  json.RawMessage
+}
+
+// clientNetworks the networks clients of an internal endpoint must connect from.
+type clientNetworks []*net.IPNet
+
+// adminRule a compiled rule with its hit counters.
+type adminRule struct {
+	Index       int    `json:"index"`
+	Name        string `json:"name,omitempty"`
+	Group       string `json:"group,omitempty"`
+	GroupMode   string `json:"groupMode"`
+	Regex       string `json:"regex"`
+	Replacement string `json:"replacement"`
+	Responses   uint64 `json:"responses"`
+	Matches     uint64 `json:"matches"`
+}
+
+// adminState the document returned by the admin endpoint.
+type adminState struct {
+	Name         string                    `json:"name"`
+	Mode         string                    `json:"mode"`
+	Config       json.RawMessage           `json:"config"`
+	Rules        []adminRule               `json:"rules"`
+	Monitoring   httputil.MonitoringConfig `json:"monitoring"`
+	RecentErrors []recentError             `json:"recentErrors"`
+	Captures     []captureEntry            `json:"captures,omitempty"`
+}
+
+// recentError an error recorded while processing a request.
+type recentError struct {
+	Time    time.Time `json:"time"`
+	Method  string    `json:"method,omitempty"`
+	Host    string    `json:"host,omitempty"`
+	Path    string    `json:"path,omitempty"`
+	Message string    `json:"message"`
+	Error   string    `json:"error"`
+}
+
+// errorRing keeps the most recent errors, replacing the oldest.
+type errorRing struct {
+	lock    sync.Mutex
+	next    int
+	entries []recentError
+}
+
+func newAdmin(config Admin, maskedConfig []byte) (*admin, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	result := &admin{
+		path:   config.Path,
+		config: maskedConfig,
+	}
+
+	if result.path == "" {
+		result.path = defaultAdminPath
+	}
+
+	var err error
+
+	if result.allowed, err = parseClientNetworks("admin", config.AllowedCIDRs); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// parseClientNetworks parse the allowedCIDRs of the named endpoint, defaulting to loopback addresses.
+func parseClientNetworks(endpoint string, cidrs []string) (clientNetworks, error) {
+	if len(cidrs) == 0 {
+		cidrs = defaultAllowedCIDRs
+	}
+
+	result := make(clientNetworks, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s allowedCIDRs entry %q: %w", endpoint, cidr, err)
+		}
+
+		result = append(result, network)
+	}
+
+	return result, nil
+}
+
+// allowedClient check the connecting address, ignoring forwarding headers that clients can set.
+func (networks clientNetworks) allowedClient(req *http.Request) bool {
+	ip := net.ParseIP(clientIP(req))
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serveAdmin write the introspection document, or 403 for clients outside the allowed networks.
+func (bodyRewrite *rewriteBody) serveAdmin(response http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		response.Header().Set("Allow", "GET, HEAD")
+		http.Error(response, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	if !bodyRewrite.admin.allowed.allowedClient(req) {
+		http.Error(response, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	state := adminState{
+		Name:         bodyRewrite.name,
+		Mode:         bodyRewrite.mode,
+		Config:       bodyRewrite.admin.config,
+		Rules:        make([]adminRule, 0),
+		Monitoring:   bodyRewrite.monitoringConfig,
+		RecentErrors: bodyRewrite.recentErrors.snapshot(),
+		Captures:     bodyRewrite.capture.snapshot(),
+	}
+
+	for _, group := range bodyRewrite.ruleGroups {
+		for _, rule := range group.rewrites {
+			counter := &bodyRewrite.metrics.rules[rule.index]
+
+			state.Rules = append(state.Rules, adminRule{
+				Index:       rule.index,
+				Name:        rule.name,
+				Group:       group.name,
+				GroupMode:   group.mode,
+				Regex:       rule.regex.String(),
+				Replacement: string(rule.replacement),
+				Responses:   atomic.LoadUint64(&counter.responses),
+				Matches:     atomic.LoadUint64(&counter.matches),
+			})
+		}
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Cache-Control", "no-store")
+
+	if err := json.NewEncoder(response).Encode(state); err != nil {
+		bodyRewrite.logger.LogErrorf("unable to write admin response: %v", err)
+	}
+}
+
+func (ring *errorRing) add(entry recentError) {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+
+	if len(ring.entries) < recentErrorLimit {
+		ring.entries = append(ring.entries, entry)
+	} else {
+		ring.entries[ring.next] = entry
+	}
+
+	ring.next = (ring.next + 1) % recentErrorLimit
+}
+
+// snapshot copy the errors from oldest to newest.
+func (ring *errorRing) snapshot() []recentError {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+
+	result := make([]recentError, 0, len(ring.entries))
+
+	if len(ring.entries) == recentErrorLimit {
+		result = append(result, ring.entries[ring.next:]...)
+		result = append(result, ring.entries[:ring.next]...)
+	} else {
+		result = append(result, ring.entries...)
+	}
+
+	return result
+}
+
+// recordError log an error for the request and keep it for the admin endpoint.
+func (bodyRewrite *rewriteBody) recordError(state *requestState, message string, err error) {
+	state.logger.Errorw(message, "error", err)
+
+	entry := recentError{
+		Time:    time.Now().UTC(),
+		Message: message,
+		Error:   err.Error(),
+	}
+
+	if state.request != nil {
+		entry.Method = state.request.Method
+		entry.Host = state.request.Host
+		entry.Path = state.request.URL.Path
+	}
+
+	bodyRewrite.recentErrors.add(entry)
+}
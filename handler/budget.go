@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"time"
+	"unicode/utf8"
+
+	"github.com/packruler/rewrite-body/compressutil"
+)
+
+const (
+	// budgetTimeout the time budget of the request ran out before or while a rule was applied.
+	budgetTimeout = "timeout"
+	// budgetInputSize the body was larger than a rule may be applied to.
+	budgetInputSize = "input-size"
+)
+
+// budgetLimits the bounds on the work spent rewriting a single response.
+type budgetLimits struct {
+	timeout      time.Duration
+	maxRuleInput int
+}
+
+func newBudgetLimits(config Budget) (budgetLimits, error) {
+	result := budgetLimits{maxRuleInput: config.MaxRuleInput}
+
+	if config.Timeout != "" {
+		timeout, err := time.ParseDuration(config.Timeout)
+		if err != nil {
+			return result, fmt.Errorf("invalid budget timeout %q: %w", config.Timeout, err)
+		}
+
+		result.timeout = timeout
+	}
+
+	return result, nil
+}
+
+const (
+	// defaultMaxDecodedSize bounds decoded content unless configured otherwise.
+	defaultMaxDecodedSize = 64 * 1024 * 1024
+	// defaultMaxDecodeRatio bounds the expansion of compressed content unless configured otherwise.
+	defaultMaxDecodeRatio = 200
+)
+
+// newDecodeLimits apply defaults to the configured limits. Negative values disable a limit.
+func newDecodeLimits(config compressutil.Limits) compressutil.Limits {
+	result := config
+
+	if result.MaxSize == 0 {
+		result.MaxSize = defaultMaxDecodedSize
+	}
+
+	if result.MaxRatio == 0 {
+		result.MaxRatio = defaultMaxDecodeRatio
+	}
+
+	return result
+}
+
+// budgetError a rule exceeded the limits of the request and rewriting was abandoned.
+type budgetError struct {
+	rule   *rewrite
+	reason string
+}
+
+func (err *budgetError) Error() string {
+	return fmt.Sprintf("rule %d %q exceeded the processing budget: %s", err.rule.index, err.rule.name, err.reason)
+}
+
+// findEdits match rule against body within the limits of the recorder.
+// With a deadline matches are found one at a time from an offset, checking the deadline before each.
+// A single match cannot be interrupted and is bounded by maxRuleInput instead.
+func (recorder *ruleRecorder) findEdits(rule *rewrite, body []byte) ([]edit, error) {
+	if recorder.maxRuleInput > 0 && len(body) > recorder.maxRuleInput {
+		return nil, &budgetError{rule: rule, reason: budgetInputSize}
+	}
+
+	if recorder.deadline.IsZero() {
+		return rule.findEdits(body), nil
+	}
+
+	if recorder.expired() {
+		return nil, &budgetError{rule: rule, reason: budgetTimeout}
+	}
+
+	// Matching from an offset hides the preceding text, so contextual rules match the whole body at once.
+	if rule.contextual {
+		return rule.findEdits(body), nil
+	}
+
+	edits := make([]edit, 0)
+	previousEnd := -1
+
+	// Follows the iteration of regexp FindAll, skipping empty matches adjacent to the previous match.
+	for offset := 0; offset <= len(body); {
+		if recorder.expired() {
+			return nil, &budgetError{rule: rule, reason: budgetTimeout}
+		}
+
+		match := rule.regex.FindSubmatchIndex(body[offset:])
+		if match == nil {
+			break
+		}
+
+		for i := range match {
+			if match[i] >= 0 {
+				match[i] += offset
+			}
+		}
+
+		accept := true
+
+		if match[1] == offset {
+			accept = match[0] != previousEnd
+			_, width := utf8.DecodeRune(body[offset:])
+			if width == 0 {
+				width = 1
+			}
+
+			offset += width
+		} else {
+			offset = match[1]
+		}
+
+		previousEnd = match[1]
+
+		if accept {
+			edits = append(edits, rule.edit(body, match))
+		}
+	}
+
+	return edits, nil
+}
+
+func (recorder *ruleRecorder) expired() bool {
+	return !time.Now().Before(recorder.deadline)
+}
+
+// isContextual report whether matches of expression depend on the text preceding them.
+func isContextual(expression string) bool {
+	parsed, err := syntax.Parse(expression, syntax.Perl)
+	if err != nil {
+		return true
+	}
+
+	return hasLeadingAssertion(parsed)
+}
+
+func hasLeadingAssertion(expression *syntax.Regexp) bool {
+	switch expression.Op {
+	case syntax.OpBeginLine, syntax.OpBeginText, syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return true
+	default:
+	}
+
+	for _, sub := range expression.Sub {
+		if hasLeadingAssertion(sub) {
+			return true
+		}
+	}
+
+	return false
+}
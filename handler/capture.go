@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultCaptureEntries = 100
+	defaultCaptureBody    = 1024 * 1024
+)
+
+// captureEntry a single captured request and response pair.
+type captureEntry struct {
+	Time            time.Time   `json:"time"`
+	Method          string      `json:"method"`
+	Host            string      `json:"host"`
+	URL             string      `json:"url"`
+	RequestHeaders  http.Header `json:"requestHeaders"`
+	Status          int         `json:"status"`
+	ResponseHeaders http.Header `json:"responseHeaders"`
+	Variant         string      `json:"variant,omitempty"`
+	Rules           []int       `json:"rules"`
+	OriginalBody    string      `json:"originalBody"`
+	RewrittenBody   string      `json:"rewrittenBody"`
+	Truncated       bool        `json:"truncated,omitempty"`
+}
+
+// captureStore a bounded ring of captured entries kept in memory or written to a directory.
+type captureStore struct {
+	sampleRate    uint64
+	triggerHeader string
+	triggerSecret string
+	// secretHeaders request headers holding secrets, masked in captured requests.
+	secretHeaders []string
+	maxEntries    int
+	maxBodySize   int
+	directory     string
+
+	requests uint64
+
+	lock    sync.Mutex
+	next    int
+	entries []captureEntry
+}
+
+// newCaptureStore create the capture settings, returning nil when disabled.
+func newCaptureStore(config Capture) (*captureStore, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	if config.SampleRate < 0 {
+		return nil, fmt.Errorf("capture sampleRate must not be negative")
+	}
+
+	if config.TriggerHeader != "" && config.TriggerSecret == "" {
+		return nil, fmt.Errorf("capture triggerHeader requires a triggerSecret")
+	}
+
+	result := &captureStore{
+		sampleRate:    uint64(config.SampleRate),
+		triggerHeader: config.TriggerHeader,
+		triggerSecret: config.TriggerSecret,
+		secretHeaders: []string{config.TriggerHeader},
+		maxEntries:    config.MaxEntries,
+		maxBodySize:   config.MaxBodySize,
+		directory:     config.Directory,
+	}
+
+	if result.maxEntries <= 0 {
+		result.maxEntries = defaultCaptureEntries
+	}
+
+	if result.maxBodySize <= 0 {
+		result.maxBodySize = defaultCaptureBody
+	}
+
+	if result.directory != "" {
+		if err := os.MkdirAll(result.directory, 0o755); err != nil {
+			return nil, fmt.Errorf("unable to create capture directory %q: %w", result.directory, err)
+		}
+	} else {
+		result.entries = make([]captureEntry, 0, result.maxEntries)
+	}
+
+	return result, nil
+}
+
+// sample determine if a request should be captured, either from the trigger header or 1 in sampleRate requests.
+func (store *captureStore) sample(req *http.Request) bool {
+	if store == nil {
+		return false
+	}
+
+	if store.triggerHeader != "" {
+		provided := req.Header.Get(store.triggerHeader)
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(store.triggerSecret)) == 1 {
+			return true
+		}
+	}
+
+	if store.sampleRate == 0 {
+		return false
+	}
+
+	return atomic.AddUint64(&store.requests, 1)%store.sampleRate == 0
+}
+
+// capture build an entry for a processed request and add it to the ring.
+func (store *captureStore) capture(state *requestState, original, rewritten []byte) error {
+	req := state.request
+	entry := captureEntry{
+		Time:            time.Now().UTC(),
+		Method:          req.Method,
+		Host:            req.Host,
+		URL:             state.logger.FormatText([]byte(req.URL.String())),
+		RequestHeaders:  state.logger.FormatHeaders(req.Header),
+		Status:          state.writer.StatusCode(),
+		ResponseHeaders: state.logger.FormatHeaders(state.writer.Header()),
+		Rules:           state.summary.rules,
+	}
+
+	for _, name := range store.secretHeaders {
+		if entry.RequestHeaders.Get(name) != "" {
+			entry.RequestHeaders.Set(name, maskedValue)
+		}
+	}
+
+	if state.variant != nil {
+		entry.Variant = state.variant.name
+	}
+
+	// Bodies are redacted like logs as captures may be written to disk.
+	entry.OriginalBody, entry.Truncated = store.limitBody(state.logger.RedactBody(original))
+
+	var truncated bool
+
+	entry.RewrittenBody, truncated = store.limitBody(state.logger.RedactBody(rewritten))
+	entry.Truncated = entry.Truncated || truncated
+
+	return store.add(entry)
+}
+
+func (store *captureStore) limitBody(body string) (string, bool) {
+	if len(body) > store.maxBodySize {
+		return body[:store.maxBodySize], true
+	}
+
+	return body, false
+}
+
+func (store *captureStore) add(entry captureEntry) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	slot := store.next
+	store.next = (store.next + 1) % store.maxEntries
+
+	if store.directory != "" {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(store.directory, fmt.Sprintf("capture-%04d.json", slot))
+
+		return os.WriteFile(path, data, 0o600)
+	}
+
+	if len(store.entries) < store.maxEntries {
+		store.entries = append(store.entries, entry)
+	} else {
+		store.entries[slot] = entry
+	}
+
+	return nil
+}
+
+// snapshot copy the in memory entries from oldest to newest. Nil when capture is disabled.
+func (store *captureStore) snapshot() []captureEntry {
+	if store == nil {
+		return nil
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	result := make([]captureEntry, 0, len(store.entries))
+
+	if len(store.entries) == store.maxEntries {
+		result = append(result, store.entries[store.next:]...)
+		result = append(result, store.entries[:store.next]...)
+	} else {
+		result = append(result, store.entries...)
+	}
+
+	return result
+}
+
+// captureResponse record the request when it was sampled, logging failures to store it.
+func (bodyRewrite *rewriteBody) captureResponse(state *requestState, original, rewritten []byte) {
+	if !state.captured {
+		return
+	}
+
+	if err := bodyRewrite.capture.capture(state, original, rewritten); err != nil {
+		bodyRewrite.recordError(state, "Unable to capture response", err)
+	}
+}
@@ -3,24 +3,187 @@ package handler
 import (
 	"regexp"
 
+	"github.com/packruler/rewrite-body/compressutil"
 	"github.com/packruler/rewrite-body/httputil"
+	"github.com/packruler/rewrite-body/logger"
+	"github.com/packruler/rewrite-body/tracing"
+)
+
+const (
+	// ModeEnforce applies rewrites to responses sent to the client.
+	ModeEnforce string = "enforce"
+	// ModeShadow runs rewrites and reports what changed but sends the original response to the client.
+	ModeShadow string = "shadow"
+)
+
+const (
+	// FallbackIdentity sends the rewritten body without Content-Encoding when it cannot be encoded.
+	FallbackIdentity string = "identity"
+	// FallbackOriginal sends the upstream body unchanged when the rewritten body cannot be encoded.
+	FallbackOriginal string = "original"
+)
+
+const (
+	// GroupChain applies every rule in order, each rule seeing the output of the previous one.
+	GroupChain string = "chain"
+	// GroupFirstMatch applies rules in order and stops after the first rule that changed the body.
+	GroupFirstMatch string = "first-match"
+	// GroupParallel matches every rule against the original body and merges non-overlapping edits.
+	GroupParallel string = "parallel"
+)
+
+const (
+	// StickyCookie assigns variants randomly and keeps them in a cookie on the client.
+	StickyCookie string = "cookie"
+	// StickyHeader assigns variants from a hash of a request header, falling back to the client IP.
+	StickyHeader string = "header"
+	// StickyIP assigns variants from a hash of the client IP.
+	StickyIP string = "ip"
 )
 
 // Rewrite holds one rewrite body configuration.
 type Rewrite struct {
+	Name        string `json:"name,omitempty" yaml:"name,omitempty" toml:"name,omitempty"`
 	Regex       string `json:"regex" yaml:"regex" toml:"regex"`
 	Replacement string `json:"replacement" yaml:"replacement" toml:"replacement"`
 }
 
+// RuleGroup holds a named set of rewrites applied with the semantics of its mode.
+type RuleGroup struct {
+	Name     string    `json:"name" yaml:"name" toml:"name"`
+	Mode     string    `json:"mode,omitempty" yaml:"mode,omitempty" toml:"mode,omitempty"`
+	Rewrites []Rewrite `json:"rewrites" yaml:"rewrites" toml:"rewrites"`
+}
+
+// Variant holds one weighted selection of rule groups within a Rollout.
+type Variant struct {
+	Name       string   `json:"name" yaml:"name" toml:"name"`
+	Weight     int      `json:"weight" yaml:"weight" toml:"weight"`
+	RuleGroups []string `json:"ruleGroups,omitempty" yaml:"ruleGroups,omitempty" toml:"ruleGroups,omitempty"`
+}
+
+// Rollout holds the configuration for assigning clients to weighted variants.
+// Rule groups referenced by any variant are only applied for clients assigned to that variant.
+type Rollout struct {
+	Stickiness    string    `json:"stickiness,omitempty" yaml:"stickiness,omitempty" toml:"stickiness,omitempty"`
+	CookieName    string    `json:"cookieName,omitempty" yaml:"cookieName,omitempty" toml:"cookieName,omitempty"`
+	HeaderName    string    `json:"headerName,omitempty" yaml:"headerName,omitempty" toml:"headerName,omitempty"`
+	VariantHeader string    `json:"variantHeader,omitempty" yaml:"variantHeader,omitempty" toml:"variantHeader,omitempty"`
+	Variants      []Variant `json:"variants,omitempty" yaml:"variants,omitempty" toml:"variants,omitempty"`
+}
+
+// DebugHeader holds the configuration for the diagnostic response header.
+// When Secret is set the header is only added to requests sending it in SecretHeader.
+type DebugHeader struct {
+	Enabled      bool   `json:"enabled" yaml:"enabled" toml:"enabled"`
+	Name         string `json:"name,omitempty" yaml:"name,omitempty" toml:"name,omitempty"`
+	Secret       string `json:"secret,omitempty" yaml:"secret,omitempty" toml:"secret,omitempty"`
+	SecretHeader string `json:"secretHeader,omitempty" yaml:"secretHeader,omitempty" toml:"secretHeader,omitempty"`
+}
+
+// DiffLog holds the configuration for logging a unified diff of the changes made by each rule.
+type DiffLog struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// Context lines of unchanged content around each change, zero for changed lines only. Defaults to 3 when unset.
+	Context *int `json:"context,omitempty" yaml:"context,omitempty" toml:"context,omitempty"`
+	// MaxLines of diff output logged per rule. Defaults to 200.
+	MaxLines int `json:"maxLines,omitempty" yaml:"maxLines,omitempty" toml:"maxLines,omitempty"`
+}
+
+// Capture holds the configuration for sampling request and response pairs into a bounded ring.
+type Capture struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// SampleRate captures 1 in SampleRate processed requests. Zero only captures triggered requests.
+	SampleRate int `json:"sampleRate,omitempty" yaml:"sampleRate,omitempty" toml:"sampleRate,omitempty"`
+	// TriggerHeader requests carrying TriggerSecret in this header are always captured.
+	TriggerHeader string `json:"triggerHeader,omitempty" yaml:"triggerHeader,omitempty" toml:"triggerHeader,omitempty"`
+	// TriggerSecret the value TriggerHeader must hold. Required when TriggerHeader is set.
+	TriggerSecret string `json:"triggerSecret,omitempty" yaml:"triggerSecret,omitempty" toml:"triggerSecret,omitempty"`
+	// MaxEntries kept before the oldest capture is replaced. Defaults to 100.
+	MaxEntries int `json:"maxEntries,omitempty" yaml:"maxEntries,omitempty" toml:"maxEntries,omitempty"`
+	// MaxBodySize in bytes of each captured body. Defaults to 1MiB.
+	MaxBodySize int `json:"maxBodySize,omitempty" yaml:"maxBodySize,omitempty" toml:"maxBodySize,omitempty"`
+	// Directory to write captures to as capture-NNNN.json. Captures are kept in memory when empty.
+	Directory string `json:"directory,omitempty" yaml:"directory,omitempty" toml:"directory,omitempty"`
+}
+
+// Budget holds the limits on the work spent rewriting a single response.
+// Responses exceeding a limit are sent unchanged.
+type Budget struct {
+	// Timeout for applying rules once the upstream response is buffered, such as "250ms". Unlimited when empty.
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty" toml:"timeout,omitempty"`
+	// MaxRuleInput bytes of body a rule may be applied to. Unlimited when zero.
+	MaxRuleInput int `json:"maxRuleInput,omitempty" yaml:"maxRuleInput,omitempty" toml:"maxRuleInput,omitempty"`
+}
+
+// Metrics holds the configuration for serving metrics in Prometheus text format.
+type Metrics struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// Path served by the middleware instead of the upstream. Defaults to /__rewrite-body/metrics.
+	Path string `json:"path,omitempty" yaml:"path,omitempty" toml:"path,omitempty"`
+	// AllowedCIDRs of clients allowed to read the metrics. Defaults to loopback addresses.
+	AllowedCIDRs []string `json:"allowedCIDRs,omitempty" yaml:"allowedCIDRs,omitempty" toml:"allowedCIDRs,omitempty"`
+}
+
+// Admin holds the configuration for the introspection endpoint.
+type Admin struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// Path served by the middleware instead of the upstream. Defaults to /__rewrite-body/.
+	Path string `json:"path,omitempty" yaml:"path,omitempty" toml:"path,omitempty"`
+	// AllowedCIDRs of clients allowed to use the endpoint. Defaults to loopback addresses.
+	AllowedCIDRs []string `json:"allowedCIDRs,omitempty" yaml:"allowedCIDRs,omitempty" toml:"allowedCIDRs,omitempty"`
+}
+
 // Config holds the plugin configuration.
 type Config struct {
-	LastModified bool                      `json:"lastModified" toml:"lastModified" yaml:"lastModified"`
-	Rewrites     []Rewrite                 `json:"rewrites" toml:"rewrites" yaml:"rewrites"`
-	LogLevel     int8                      `json:"logLevel" toml:"logLevel" yaml:"logLevel"`
-	Monitoring   httputil.MonitoringConfig `json:"monitoring" toml:"monitoring" yaml:"monitoring"`
+	LastModified          bool                      `json:"lastModified" toml:"lastModified" yaml:"lastModified"`
+	Mode                  string                    `json:"mode,omitempty" toml:"mode,omitempty" yaml:"mode,omitempty"`
+	EncodeFallback        string                    `json:"encodeFallback,omitempty" toml:"encodeFallback,omitempty" yaml:"encodeFallback,omitempty"`
+	Rewrites              []Rewrite                 `json:"rewrites" toml:"rewrites" yaml:"rewrites"`
+	RuleGroups            []RuleGroup               `json:"ruleGroups,omitempty" toml:"ruleGroups,omitempty" yaml:"ruleGroups,omitempty"`
+	Rollout               Rollout                   `json:"rollout,omitempty" toml:"rollout,omitempty" yaml:"rollout,omitempty"`
+	DebugHeader           DebugHeader               `json:"debugHeader,omitempty" toml:"debugHeader,omitempty" yaml:"debugHeader,omitempty"`
+	DecodeLimits          compressutil.Limits       `json:"decodeLimits,omitempty" toml:"decodeLimits,omitempty" yaml:"decodeLimits,omitempty"`
+	CompressionLevels     map[string]int            `json:"compressionLevels,omitempty" toml:"compressionLevels,omitempty" yaml:"compressionLevels,omitempty"`
+	ParallelCompression   compressutil.Parallel     `json:"parallelCompression,omitempty" toml:"parallelCompression,omitempty" yaml:"parallelCompression,omitempty"`
+	CompressionThresholds compressutil.Thresholds   `json:"compressionThresholds,omitempty" toml:"compressionThresholds,omitempty" yaml:"compressionThresholds,omitempty"`
+	Budget                Budget                    `json:"budget,omitempty" toml:"budget,omitempty" yaml:"budget,omitempty"`
+	Tracing               tracing.Config            `json:"tracing,omitempty" toml:"tracing,omitempty" yaml:"tracing,omitempty"`
+	Admin                 Admin                     `json:"admin,omitempty" toml:"admin,omitempty" yaml:"admin,omitempty"`
+	Metrics               Metrics                   `json:"metrics,omitempty" toml:"metrics,omitempty" yaml:"metrics,omitempty"`
+	Capture               Capture                   `json:"capture,omitempty" toml:"capture,omitempty" yaml:"capture,omitempty"`
+	LogLevel              string                    `json:"logLevel" toml:"logLevel" yaml:"logLevel"`
+	LogLevels             map[string]string         `json:"logLevels,omitempty" toml:"logLevels,omitempty" yaml:"logLevels,omitempty"`
+	LogFormat             string                    `json:"logFormat,omitempty" toml:"logFormat,omitempty" yaml:"logFormat,omitempty"`
+	LogSinks              []logger.SinkConfig       `json:"logSinks,omitempty" toml:"logSinks,omitempty" yaml:"logSinks,omitempty"`
+	LogDiff               DiffLog                   `json:"logDiff,omitempty" toml:"logDiff,omitempty" yaml:"logDiff,omitempty"`
+	LogRedaction          logger.RedactionConfig    `json:"logRedaction,omitempty" toml:"logRedaction,omitempty" yaml:"logRedaction,omitempty"`
+	Monitoring            httputil.MonitoringConfig `json:"monitoring" toml:"monitoring" yaml:"monitoring"`
 }
 
 type rewrite struct {
+	index       int
+	name        string
 	regex       *regexp.Regexp
 	replacement []byte
+	// contextual matches depend on the text preceding them, such as ^ or \b, so the regex must see the whole body.
+	contextual bool
+}
+
+// ensureProperFormat handle weird yaml parsing of every list option, as done for Monitoring.
+func (config *Config) ensureProperFormat() {
+	config.Monitoring.EnsureProperFormat()
+
+	config.LogRedaction.Patterns = httputil.ProperList(config.LogRedaction.Patterns)
+	config.LogRedaction.Headers = httputil.ProperList(config.LogRedaction.Headers)
+	config.Metrics.AllowedCIDRs = httputil.ProperList(config.Metrics.AllowedCIDRs)
+	config.Admin.AllowedCIDRs = httputil.ProperList(config.Admin.AllowedCIDRs)
+
+	for i := range config.Rollout.Variants {
+		config.Rollout.Variants[i].RuleGroups = httputil.ProperList(config.Rollout.Variants[i].RuleGroups)
+	}
+
+	for i := range config.LogSinks {
+		config.LogSinks[i].Levels = httputil.ProperList(config.LogSinks[i].Levels)
+	}
 }
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/packruler/rewrite-body/compressutil"
+)
+
+const (
+	defaultDebugHeaderName   = "X-Rewrite-Body"
+	defaultDebugSecretHeader = "X-Rewrite-Body-Debug"
+
+	// skipDecode response content could not be decoded.
+	skipDecode = "decode"
+	// skipDecodeLimit response content expanded beyond the decode limits.
+	skipDecodeLimit = "decode-limit"
+	// skipEmpty response content was empty after decoding.
+	skipEmpty = "empty"
+	// skipBudget rewriting was abandoned after exceeding the processing budget.
+	skipBudget = "budget"
+	// skipEncode the rewritten content could not be encoded and the original was sent.
+	skipEncode = "encode"
+)
+
+type diagnostic struct {
+	name         string
+	secret       string
+	secretHeader string
+}
+
+// newDiagnostic create the diagnostic header settings, returning nil when disabled.
+func newDiagnostic(config DebugHeader) *diagnostic {
+	if !config.Enabled {
+		return nil
+	}
+
+	result := &diagnostic{
+		name:         config.Name,
+		secret:       config.Secret,
+		secretHeader: config.SecretHeader,
+	}
+
+	if result.name == "" {
+		result.name = defaultDebugHeaderName
+	}
+
+	if result.secretHeader == "" {
+		result.secretHeader = defaultDebugSecretHeader
+	}
+
+	return result
+}
+
+// allowed determine if the diagnostic header should be added to the response for req.
+func (config *diagnostic) allowed(req *http.Request) bool {
+	if config == nil {
+		return false
+	}
+
+	if config.secret == "" {
+		return true
+	}
+
+	provided := req.Header.Get(config.secretHeader)
+
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(config.secret)) == 1
+}
+
+// skippedDiagnostic format the header value for a response left unchanged.
+func skippedDiagnostic(reason string) string {
+	return "skipped; reason=" + reason
+}
+
+// appliedDiagnostic format the header value for a processed response.
+func appliedDiagnostic(mode string, matches []ruleMatch, encoding string, elapsed time.Duration) string {
+	state := "applied"
+	if mode == ModeShadow {
+		state = ModeShadow
+	}
+
+	indexes, total := matchedRules(matches)
+
+	rules := make([]string, 0, len(indexes))
+	for _, index := range indexes {
+		rules = append(rules, strconv.Itoa(index))
+	}
+
+	if encoding == "" {
+		encoding = compressutil.Identity
+	}
+
+	return fmt.Sprintf(
+		"%s; rules=%s; matches=%d; decoded=%s; ms=%.1f",
+		state,
+		strings.Join(rules, ","),
+		total,
+		encoding,
+		milliseconds(elapsed),
+	)
+}
+
+// milliseconds convert a duration to fractional milliseconds.
+func milliseconds(elapsed time.Duration) float64 {
+	return float64(elapsed) / float64(time.Millisecond)
+}
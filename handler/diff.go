@@ -0,0 +1,297 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultDiffContext  = 3
+	defaultDiffMaxLines = 200
+	// maxDiffEdits bounds the work spent finding a minimal diff before falling back to a single hunk.
+	maxDiffEdits = 500
+)
+
+type diffOp struct {
+	kind byte
+	line []byte
+}
+
+type diffOptions struct {
+	context  int
+	maxLines int
+}
+
+func newDiffOptions(config DiffLog) *diffOptions {
+	if !config.Enabled {
+		return nil
+	}
+
+	result := &diffOptions{
+		context:  defaultDiffContext,
+		maxLines: config.MaxLines,
+	}
+
+	if config.Context != nil && *config.Context >= 0 {
+		result.context = *config.Context
+	}
+
+	if result.maxLines <= 0 {
+		result.maxLines = defaultDiffMaxLines
+	}
+
+	return result
+}
+
+// unifiedDiff describe the line changes between before and after as unified diff hunks.
+func unifiedDiff(before, after []byte, options *diffOptions) string {
+	beforeLines := splitLines(before)
+	afterLines := splitLines(after)
+
+	// Trim the common prefix and suffix so the search only covers the changed region.
+	prefix := 0
+	for prefix < len(beforeLines) && prefix < len(afterLines) && bytes.Equal(beforeLines[prefix], afterLines[prefix]) {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(beforeLines)-prefix && suffix < len(afterLines)-prefix &&
+		bytes.Equal(beforeLines[len(beforeLines)-1-suffix], afterLines[len(afterLines)-1-suffix]) {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, prefix+suffix)
+	for _, line := range beforeLines[:prefix] {
+		ops = append(ops, diffOp{kind: ' ', line: line})
+	}
+
+	ops = append(ops, diffLines(beforeLines[prefix:len(beforeLines)-suffix], afterLines[prefix:len(afterLines)-suffix])...)
+
+	for _, line := range beforeLines[len(beforeLines)-suffix:] {
+		ops = append(ops, diffOp{kind: ' ', line: line})
+	}
+
+	return formatHunks(ops, options)
+}
+
+// splitLines split content after each newline, without an empty entry for a trailing newline.
+func splitLines(content []byte) [][]byte {
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// diffLines find the shortest edit script between two lists of lines using Myers' algorithm.
+// When more than maxDiffEdits are required every line is reported as removed and added.
+func diffLines(before, after [][]byte) []diffOp {
+	limit := len(before) + len(after)
+	if limit > maxDiffEdits {
+		limit = maxDiffEdits
+	}
+
+	offset := limit + 1
+	frontier := make([]int, 2*limit+3)
+	trace := make([][]int, 0)
+
+	for distance := 0; distance <= limit; distance++ {
+		trace = append(trace, append([]int(nil), frontier...))
+
+		for diagonal := -distance; diagonal <= distance; diagonal += 2 {
+			var x int
+			if diagonal == -distance || (diagonal != distance && frontier[offset+diagonal-1] < frontier[offset+diagonal+1]) {
+				x = frontier[offset+diagonal+1]
+			} else {
+				x = frontier[offset+diagonal-1] + 1
+			}
+
+			y := x - diagonal
+			for x < len(before) && y < len(after) && bytes.Equal(before[x], after[y]) {
+				x++
+				y++
+			}
+
+			frontier[offset+diagonal] = x
+
+			if x >= len(before) && y >= len(after) {
+				return backtrackDiff(trace, offset, before, after)
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(before)+len(after))
+	for _, line := range before {
+		ops = append(ops, diffOp{kind: '-', line: line})
+	}
+
+	for _, line := range after {
+		ops = append(ops, diffOp{kind: '+', line: line})
+	}
+
+	return ops
+}
+
+func backtrackDiff(trace [][]int, offset int, before, after [][]byte) []diffOp {
+	x, y := len(before), len(after)
+	reversed := make([]diffOp, 0, x+y)
+
+	for distance := len(trace) - 1; distance > 0; distance-- {
+		frontier := trace[distance]
+		diagonal := x - y
+
+		previous := diagonal - 1
+		if diagonal == -distance || (diagonal != distance && frontier[offset+diagonal-1] < frontier[offset+diagonal+1]) {
+			previous = diagonal + 1
+		}
+
+		previousX := frontier[offset+previous]
+		previousY := previousX - previous
+
+		for x > previousX && y > previousY {
+			reversed = append(reversed, diffOp{kind: ' ', line: before[x-1]})
+			x--
+			y--
+		}
+
+		if x == previousX {
+			reversed = append(reversed, diffOp{kind: '+', line: after[y-1]})
+			y--
+		} else {
+			reversed = append(reversed, diffOp{kind: '-', line: before[x-1]})
+			x--
+		}
+	}
+
+	for x > 0 && y > 0 {
+		reversed = append(reversed, diffOp{kind: ' ', line: before[x-1]})
+		x--
+		y--
+	}
+
+	ops := make([]diffOp, len(reversed))
+	for i := range reversed {
+		ops[i] = reversed[len(reversed)-1-i]
+	}
+
+	return ops
+}
+
+// formatHunks write the changed operations with surrounding context as unified diff hunks.
+func formatHunks(ops []diffOp, options *diffOptions) string {
+	var builder strings.Builder
+
+	lines := 0
+	index := 0
+
+	for index < len(ops) {
+		for index < len(ops) && ops[index].kind == ' ' {
+			index++
+		}
+
+		if index == len(ops) {
+			break
+		}
+
+		start := index - options.context
+		if start < 0 {
+			start = 0
+		}
+
+		// Extend the hunk until the unchanged run after a change exceeds twice the context.
+		end := index
+		for end < len(ops) {
+			unchanged := 0
+			for end+unchanged < len(ops) && ops[end+unchanged].kind == ' ' {
+				unchanged++
+			}
+
+			if end+unchanged == len(ops) || unchanged > 2*options.context {
+				end += minInt(unchanged, options.context)
+
+				break
+			}
+
+			end += unchanged + 1
+		}
+
+		written, complete := writeHunk(&builder, ops, start, end, options.maxLines-lines)
+		lines += written
+		index = end
+
+		if !complete || (lines >= options.maxLines && hasChanges(ops[end:])) {
+			builder.WriteString("... diff truncated\n")
+
+			break
+		}
+	}
+
+	return builder.String()
+}
+
+// writeHunk write the hunk of ops[start:end] using at most maxLines lines, including its header.
+// It returns the lines written and whether the whole hunk fit.
+func writeHunk(builder *strings.Builder, ops []diffOp, start, end, maxLines int) (int, bool) {
+	beforeStart, afterStart := 1, 1
+
+	for _, op := range ops[:start] {
+		if op.kind != '+' {
+			beforeStart++
+		}
+
+		if op.kind != '-' {
+			afterStart++
+		}
+	}
+
+	beforeCount, afterCount := 0, 0
+
+	for _, op := range ops[start:end] {
+		if op.kind != '+' {
+			beforeCount++
+		}
+
+		if op.kind != '-' {
+			afterCount++
+		}
+	}
+
+	fmt.Fprintf(builder, "@@ -%d,%d +%d,%d @@\n", beforeStart, beforeCount, afterStart, afterCount)
+
+	written := 1
+
+	for _, op := range ops[start:end] {
+		if written >= maxLines {
+			return written, false
+		}
+
+		builder.WriteByte(op.kind)
+		builder.Write(bytes.TrimSuffix(op.line, []byte("\n")))
+		builder.WriteByte('\n')
+
+		written++
+	}
+
+	return written, true
+}
+
+// hasChanges determine if any of ops adds or removes a line.
+func hasChanges(ops []diffOp) bool {
+	for _, op := range ops {
+		if op.kind != ' ' {
+			return true
+		}
+	}
+
+	return false
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -7,119 +7,592 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"regexp"
+	"sync/atomic"
+	"time"
 
+	"github.com/packruler/rewrite-body/compressutil"
 	"github.com/packruler/rewrite-body/httputil"
 	"github.com/packruler/rewrite-body/logger"
+	"github.com/packruler/rewrite-body/tracing"
 )
 
+// maskedValue replaces secrets in configuration written to logs.
+const maskedValue = "********"
+
 type rewriteBody struct {
-	name             string
-	next             http.Handler
-	rewrites         []rewrite
-	lastModified     bool
-	logger           logger.LogWriter
-	monitoringConfig httputil.MonitoringConfig
+	name              string
+	next              http.Handler
+	mode              string
+	ruleGroups        []ruleGroup
+	rollout           *rollout
+	diagnostic        *diagnostic
+	diffOptions       *diffOptions
+	capture           *captureStore
+	metrics           *metrics
+	tracer            *tracing.Tracer
+	metricsPath       string
+	metricsAllowed    clientNetworks
+	admin             *admin
+	recentErrors      *errorRing
+	budget            budgetLimits
+	encodeFallback    string
+	decodeLimits      compressutil.Limits
+	compressionLevels map[string]int
+	parallel          compressutil.Parallel
+	thresholds        compressutil.Thresholds
+	lastModified      bool
+	logger            logger.LogWriter
+	monitoringConfig  httputil.MonitoringConfig
 }
 
 // New creates and returns a new rewrite body plugin instance.
 func New(_ context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-	rewrites := make([]rewrite, len(config.Rewrites))
+	config.ensureProperFormat()
 
-	for index, rewriteConfig := range config.Rewrites {
-		regex, err := regexp.Compile(rewriteConfig.Regex)
-		if err != nil {
-			return nil, fmt.Errorf("error compiling regex %q: %w", rewriteConfig.Regex, err)
-		}
+	mode, encodeFallback, err := validateModes(config)
+	if err != nil {
+		return nil, err
+	}
 
-		rewrites[index] = rewrite{
-			regex:       regex,
-			replacement: []byte(rewriteConfig.Replacement),
-		}
+	ruleGroups, err := compileRuleGroups(config)
+	if err != nil {
+		return nil, err
 	}
 
-	logWriter := *logger.CreateLogger(logger.LogLevel(config.LogLevel))
+	rollout, err := newRollout(config.Rollout, ruleGroups)
+	if err != nil {
+		return nil, err
+	}
+
+	budget, err := newBudgetLimits(config.Budget)
+	if err != nil {
+		return nil, err
+	}
+
+	logWriter, err := createLogger(config, name)
+	if err != nil {
+		return nil, err
+	}
 
 	config.Monitoring.EnsureDefaults()
-	config.Monitoring.EnsureProperFormat()
 
 	result := &rewriteBody{
-		name:             name,
-		next:             next,
-		rewrites:         rewrites,
-		lastModified:     config.LastModified,
-		logger:           logWriter,
-		monitoringConfig: config.Monitoring,
+		name:              name,
+		next:              next,
+		mode:              mode,
+		ruleGroups:        ruleGroups,
+		rollout:           rollout,
+		diagnostic:        newDiagnostic(config.DebugHeader),
+		diffOptions:       newDiffOptions(config.LogDiff),
+		metrics:           newMetrics(ruleGroups),
+		recentErrors:      &errorRing{},
+		budget:            budget,
+		encodeFallback:    encodeFallback,
+		decodeLimits:      newDecodeLimits(config.DecodeLimits),
+		compressionLevels: config.CompressionLevels,
+		parallel:          config.ParallelCompression,
+		thresholds:        config.CompressionThresholds,
+		lastModified:      config.LastModified,
+		logger:            *logWriter,
+		monitoringConfig:  config.Monitoring,
 	}
 
-	data, _ := json.Marshal(config)
+	if err := result.configureOptional(config); err != nil {
+		return nil, err
+	}
 
-	logWriter.LogDebugf("Initial config: %v", string(data))
+	logWriter.LogDebugf("Initial config: %v", logWriter.FormatText(maskedConfig(config)))
 
 	return result, nil
 }
 
+// validateModes check the mode and encodeFallback of config, returning them with defaults applied.
+func validateModes(config *Config) (string, string, error) {
+	mode := config.Mode
+	if mode == "" {
+		mode = ModeEnforce
+	}
+
+	if mode != ModeEnforce && mode != ModeShadow {
+		return "", "", fmt.Errorf("unknown mode %q", config.Mode)
+	}
+
+	encodeFallback := config.EncodeFallback
+	if encodeFallback == "" {
+		encodeFallback = FallbackIdentity
+	}
+
+	if encodeFallback != FallbackIdentity && encodeFallback != FallbackOriginal {
+		return "", "", fmt.Errorf("unknown encodeFallback %q", config.EncodeFallback)
+	}
+
+	return mode, encodeFallback, nil
+}
+
+// createLogger build the LogWriter described by the logging options of config.
+func createLogger(config *Config, name string) (*logger.LogWriter, error) {
+	if config.LogFormat != "" && config.LogFormat != logger.FormatText && config.LogFormat != logger.FormatJSON {
+		return nil, fmt.Errorf("unknown log format %q", config.LogFormat)
+	}
+
+	// Levels are strings as Traefik decodes plugin configuration without calling UnmarshalText.
+	level, err := logger.ParseLevel(config.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := make(map[string]logger.LogLevel, len(config.LogLevels))
+
+	for subsystem, value := range config.LogLevels {
+		if overrides[subsystem], err = logger.ParseLevel(value); err != nil {
+			return nil, fmt.Errorf("log level of %s: %w", subsystem, err)
+		}
+	}
+
+	redactor, err := logger.NewRedactor(config.LogRedaction)
+	if err != nil {
+		return nil, err
+	}
+
+	return logger.CreateLoggerFromConfig(logger.Config{
+		Level:     level,
+		Format:    config.LogFormat,
+		Name:      name,
+		Overrides: overrides,
+		Redactor:  redactor,
+		Sinks:     config.LogSinks,
+	})
+}
+
+// configureOptional set up the optional capture, tracing, admin and metrics features.
+func (bodyRewrite *rewriteBody) configureOptional(config *Config) error {
+	for encoding, level := range config.CompressionLevels {
+		if err := compressutil.CheckLevel(encoding, level); err != nil {
+			return err
+		}
+	}
+
+	if err := config.ParallelCompression.Check(); err != nil {
+		return err
+	}
+
+	if err := config.CompressionThresholds.Check(); err != nil {
+		return err
+	}
+
+	var err error
+
+	if bodyRewrite.capture, err = newCaptureStore(config.Capture); err != nil {
+		return err
+	}
+
+	if bodyRewrite.capture != nil && bodyRewrite.diagnostic != nil {
+		bodyRewrite.capture.secretHeaders = append(bodyRewrite.capture.secretHeaders, bodyRewrite.diagnostic.secretHeader)
+	}
+
+	if bodyRewrite.tracer, err = tracing.NewTracer(config.Tracing); err != nil {
+		return err
+	}
+
+	if bodyRewrite.admin, err = newAdmin(config.Admin, maskedConfig(config)); err != nil {
+		return err
+	}
+
+	return bodyRewrite.configureMetrics(config.Metrics)
+}
+
+// configureMetrics set up the metrics endpoint when enabled.
+func (bodyRewrite *rewriteBody) configureMetrics(config Metrics) error {
+	if !config.Enabled {
+		return nil
+	}
+
+	var err error
+
+	if bodyRewrite.metricsAllowed, err = parseClientNetworks("metrics", config.AllowedCIDRs); err != nil {
+		return err
+	}
+
+	bodyRewrite.metricsPath = config.Path
+	if bodyRewrite.metricsPath == "" {
+		bodyRewrite.metricsPath = defaultMetricsPath
+	}
+
+	return nil
+}
+
 func (bodyRewrite *rewriteBody) ServeHTTP(response http.ResponseWriter, req *http.Request) {
 	defer bodyRewrite.handlePanic()
 
-	wrappedRequest := httputil.WrapRequest(req, bodyRewrite.monitoringConfig, bodyRewrite.logger)
+	if bodyRewrite.serveInternal(response, req) {
+		return
+	}
+
+	atomic.AddUint64(&bodyRewrite.metrics.requests, 1)
+
+	requestLogger := bodyRewrite.logger.With("method", req.Method, "host", req.Host, "path", req.URL.Path)
+	httpLogger := requestLogger.WithSubsystem(logger.SubsystemHTTP)
+	state := &requestState{
+		logger:  requestLogger.WithSubsystem(logger.SubsystemHandler),
+		request: req,
+		debug:   bodyRewrite.diagnostic.allowed(req),
+		start:   time.Now(),
+	}
+
+	wrappedRequest := httputil.WrapRequest(req, bodyRewrite.monitoringConfig, httpLogger)
 	// allow default http.ResponseWriter to handle calls targeting WebSocket upgrades and non GET methods
-	if !wrappedRequest.SupportsProcessing() {
-		bodyRewrite.logger.LogDebugf("Ignoring unsupported request: %v", req)
-		bodyRewrite.next.ServeHTTP(response, req)
+	if reason := wrappedRequest.SkipReason(); reason != "" {
+		bodyRewrite.serveUnsupported(response, state, reason)
 
 		return
 	}
 
-	bodyRewrite.logger.LogDebugf("Starting supported request: %v", req)
+	state.logger.Debugw(
+		"Starting supported request",
+		"url", state.logger.FormatText([]byte(req.URL.String())),
+		"headers", state.logger.FormatHeaders(req.Header),
+	)
+
+	state.writer = bodyRewrite.wrapWriter(response, httpLogger)
+	state.captured = bodyRewrite.capture.sample(req)
+
+	if bodyRewrite.rollout != nil {
+		state.variant = bodyRewrite.rollout.assign(req, state.writer)
+	}
+
+	forwardedRequest := wrappedRequest.CloneWithSupportedEncoding()
+	bodyRewrite.startTrace(state, forwardedRequest)
+
+	bufferSpan := state.span.StartChild("buffer")
+	// look into using https://pkg.go.dev/net/http#RoundTripper
+	bodyRewrite.next.ServeHTTP(state.writer, forwardedRequest)
+	bufferSpan.SetAttribute("bytes", state.writer.GetBuffer().Len())
+	_ = bufferSpan.Finish()
+
+	bodyRewrite.processResponse(state)
+	bodyRewrite.metrics.observe(state)
+	logSummary(state, state.writer)
+	bodyRewrite.finishTrace(state)
+}
 
-	wrappedWriter := httputil.WrapWriter(
+// wrapWriter buffer the upstream response with the settings of the middleware.
+func (bodyRewrite *rewriteBody) wrapWriter(response http.ResponseWriter, httpLogger logger.LogWriter) *httputil.ResponseWrapper {
+	writer := httputil.WrapWriter(
 		response,
 		bodyRewrite.monitoringConfig,
-		bodyRewrite.logger,
+		httpLogger,
 		bodyRewrite.lastModified,
 	)
 
-	wrappedWriter.SetLastModified(bodyRewrite.lastModified)
+	writer.SetLastModified(bodyRewrite.lastModified)
+	writer.SetDecodeLimits(bodyRewrite.decodeLimits)
+	writer.SetCompressionLevels(bodyRewrite.compressionLevels)
+	writer.SetParallelCompression(bodyRewrite.parallel)
+	writer.SetCompressionThresholds(bodyRewrite.thresholds)
 
-	// look into using https://pkg.go.dev/net/http#RoundTripper
-	bodyRewrite.next.ServeHTTP(wrappedWriter, wrappedRequest.CloneWithSupportedEncoding())
+	return writer
+}
+
+// serveUnsupported forward a request that is not eligible for rewriting directly to the next handler.
+func (bodyRewrite *rewriteBody) serveUnsupported(response http.ResponseWriter, state *requestState, reason string) {
+	state.logger.Debugw("Ignoring unsupported request", "reason", reason)
+	bodyRewrite.metrics.addSkipped(reason)
 
-	if !wrappedWriter.SupportsProcessing() {
-		// We are ignoring these any errors because the content should be unchanged here.
-		// This could "error" if writing is not supported but content will return properly.
-		_, _ = response.Write(wrappedWriter.GetBuffer().Bytes())
-		bodyRewrite.logger.LogDebugf("Ignoring unsupported response: %v", wrappedWriter)
+	if state.debug {
+		response.Header().Set(bodyRewrite.diagnostic.name, skippedDiagnostic(reason))
+	}
+
+	// The summary is only logged at Info, so the upstream otherwise gets the original writer.
+	if !state.logger.Enabled(logger.Info) {
+		bodyRewrite.next.ServeHTTP(response, state.request)
 
 		return
 	}
 
-	bodyBytes, err := wrappedWriter.GetContent()
-	if err != nil {
-		bodyRewrite.logger.LogErrorf("Error loading content: %v", err)
+	stats := &statsWriter{ResponseWriter: response}
+	bodyRewrite.next.ServeHTTP(stats, state.request)
 
-		if _, err := response.Write(wrappedWriter.GetBuffer().Bytes()); err != nil {
-			bodyRewrite.logger.LogErrorf("unable to write error content: %v", err)
-		}
+	state.summary.skipReason = reason
+	state.summary.contentType = stats.Header().Get("Content-Type")
+
+	logSummary(state, stats)
+}
+
+// startTrace start the root span of the request and propagate it to the request sent upstream.
+func (bodyRewrite *rewriteBody) startTrace(state *requestState, forwardedRequest *http.Request) {
+	req := state.request
+
+	state.span = bodyRewrite.tracer.StartTrace("rewrite-body", req.Header.Get(tracing.TraceparentHeader))
+	if state.span == nil {
+		return
+	}
+
+	state.span.SetAttribute("http.method", req.Method)
+	state.span.SetAttribute("http.target", req.URL.Path)
+	state.span.SetAttribute("middleware", bodyRewrite.name)
+
+	forwardedRequest.Header.Set(tracing.TraceparentHeader, state.span.Traceparent())
+}
+
+// finishTrace end the root span of the request, exporting the trace.
+func (bodyRewrite *rewriteBody) finishTrace(state *requestState) {
+	state.span.SetAttribute("http.status_code", state.writer.StatusCode())
+	state.span.SetAttribute("skip_reason", state.summary.skipReason)
+
+	if err := state.span.Finish(); err != nil {
+		state.logger.Warningw("Unable to export trace", "error", err)
+	}
+}
+
+// serveInternal answer requests for paths served by the middleware itself, returning false for any other request.
+func (bodyRewrite *rewriteBody) serveInternal(response http.ResponseWriter, req *http.Request) bool {
+	if bodyRewrite.metricsPath != "" && req.URL.Path == bodyRewrite.metricsPath {
+		bodyRewrite.serveMetrics(response, req)
+
+		return true
+	}
+
+	if bodyRewrite.admin != nil && req.URL.Path == bodyRewrite.admin.path {
+		bodyRewrite.serveAdmin(response, req)
+
+		return true
+	}
+
+	return false
+}
+
+// processResponse rewrite the buffered upstream response and write the result to the client.
+func (bodyRewrite *rewriteBody) processResponse(state *requestState) {
+	if bodyRewrite.budget.timeout > 0 {
+		state.deadline = time.Now().Add(bodyRewrite.budget.timeout)
+	}
+
+	state.summary.contentType = state.writer.Header().Get("Content-Type")
+	state.summary.encodingIn = state.writer.Header().Get("Content-Encoding")
+	state.summary.originalSize = state.writer.GetBuffer().Len()
+
+	if reason := state.writer.SkipReason(); reason != "" {
+		state.logger.Debugw("Ignoring unsupported response", "reason", reason)
+		bodyRewrite.passThrough(state, reason)
+
+		return
+	}
 
+	bodyBytes, ok := bodyRewrite.decodeContent(state)
+	if !ok {
 		return
 	}
 
-	bodyRewrite.logger.LogDebugf("Response body: %s", bodyBytes)
+	state.summary.decodedSize = len(bodyBytes)
+	// FormatBody redacts and copies the whole body, so only pay for it when the entry is written.
+	if state.logger.Enabled(logger.Debug) {
+		state.logger.Debugw("Response body", "body", state.logger.FormatBody(bodyBytes))
+	}
 
 	if len(bodyBytes) == 0 {
 		// If the body is empty there is no purpose in continuing this process.
+		bodyRewrite.passThrough(state, skipEmpty)
+
+		return
+	}
+
+	rewrittenBytes, matches, ok := bodyRewrite.applyRules(state, bodyBytes)
+	if !ok {
 		return
 	}
 
-	for _, rwt := range bodyRewrite.rewrites {
-		bodyBytes = rwt.regex.ReplaceAll(bodyBytes, rwt.replacement)
+	bodyRewrite.captureResponse(state, bodyBytes, rewrittenBytes)
+
+	if state.debug {
+		state.writer.SetDiagnostic(
+			bodyRewrite.diagnostic.name,
+			appliedDiagnostic(bodyRewrite.mode, matches, state.summary.encodingIn, time.Since(state.start)),
+		)
+	}
+
+	if bodyRewrite.mode == ModeShadow {
+		reportShadow(state.logger, bodyBytes, rewrittenBytes, matches)
+		state.debug = false
+		bodyRewrite.passThrough(state, "")
+
+		return
+	}
+
+	bodyRewrite.writeContent(state, rewrittenBytes)
+}
+
+// writeContent encode the rewritten body with the upstream encoding and write it to the client.
+// When encoding fails the configured fallback is written instead.
+func (bodyRewrite *rewriteBody) writeContent(state *requestState, rewrittenBytes []byte) {
+	encodeSpan := state.span.StartChild("encode")
+	encodeSpan.SetAttribute("encoding", state.summary.encodingIn)
+	encodeSpan.SetAttribute("bytes.in", len(rewrittenBytes))
+
+	if err := state.writer.SetContent(rewrittenBytes, state.summary.encodingIn); err != nil {
+		bodyRewrite.recordError(state, "Error encoding content", err)
+		atomic.AddUint64(&bodyRewrite.metrics.encodeErrors, 1)
+		encodeSpan.SetAttribute("error", err.Error())
+		encodeSpan.SetAttribute("fallback", bodyRewrite.encodeFallback)
+
+		// The identity fallback cannot replace a Content-Encoding that was already flushed.
+		if bodyRewrite.encodeFallback == FallbackOriginal || state.writer.SetIdentityContent(rewrittenBytes) != nil {
+			bodyRewrite.passThrough(state, skipEncode)
+		}
+	}
+
+	encodeSpan.SetAttribute("bytes.out", state.writer.BytesWritten())
+	_ = encodeSpan.Finish()
+}
+
+// decodeContent decode the buffered upstream body, passing the response through when it cannot be decoded.
+func (bodyRewrite *rewriteBody) decodeContent(state *requestState) ([]byte, bool) {
+	decodeSpan := state.span.StartChild("decode")
+	decodeSpan.SetAttribute("encoding", state.summary.encodingIn)
+	decodeSpan.SetAttribute("bytes.in", state.summary.originalSize)
+
+	bodyBytes, err := state.writer.GetContent()
+
+	decodeSpan.SetAttribute("bytes.out", len(bodyBytes))
+	_ = decodeSpan.Finish()
+
+	if err != nil {
+		bodyRewrite.recordError(state, "Error loading content", err)
+		bodyRewrite.metrics.addDecodeError(err)
+
+		// Content expanding beyond the limits is sent as the original compressed bytes.
+		if errors.Is(err, compressutil.ErrSizeLimit) {
+			bodyRewrite.passThrough(state, skipDecodeLimit)
+		} else {
+			bodyRewrite.passThrough(state, skipDecode)
+		}
+
+		return nil, false
+	}
+
+	return bodyBytes, true
+}
+
+// applyRules rewrite the decoded body, recording the outcome in the summary, metrics and trace.
+// The original body is written instead when the processing budget is exceeded.
+func (bodyRewrite *rewriteBody) applyRules(state *requestState, bodyBytes []byte) ([]byte, []ruleMatch, bool) {
+	rewriteStart := time.Now()
+	rulesSpan := state.span.StartChild("rules")
+	rewrittenBytes, matches, err := bodyRewrite.rewriteContent(state, bodyBytes)
+	if err != nil {
+		rulesSpan.SetAttribute("error", err.Error())
+		_ = rulesSpan.Finish()
+
+		bodyRewrite.abandonRewrite(state, err)
+
+		return nil, nil, false
+	}
+
+	state.summary.rewrittenSize = len(rewrittenBytes)
+	state.summary.rules, state.summary.matches = matchedRules(matches)
+	bodyRewrite.metrics.addMatches(matches)
+
+	rulesSpan.SetAttribute("bytes.in", len(bodyBytes))
+	rulesSpan.SetAttribute("bytes.out", len(rewrittenBytes))
+	rulesSpan.SetAttribute("rules.matched", len(state.summary.rules))
+	rulesSpan.SetAttribute("matches", state.summary.matches)
+	_ = rulesSpan.Finish()
+
+	state.logger.Debugw(
+		"Applied rewrites",
+		"rules", state.summary.rules,
+		"matches", state.summary.matches,
+		"durationMs", milliseconds(time.Since(rewriteStart)),
+	)
+
+	if state.logger.Enabled(logger.Debug) {
+		state.logger.Debugw("Transformed body", "body", state.logger.FormatBody(rewrittenBytes))
+	}
+
+	return rewrittenBytes, matches, true
+}
+
+// abandonRewrite write the original body after a rule exceeded the processing budget.
+func (bodyRewrite *rewriteBody) abandonRewrite(state *requestState, err error) {
+	var exceeded *budgetError
+	if errors.As(err, &exceeded) {
+		state.logger.Warningw(
+			"Abandoned rewriting",
+			"rule", exceeded.rule.index,
+			"name", exceeded.rule.name,
+			"reason", exceeded.reason,
+			"durationMs", milliseconds(time.Since(state.start)),
+		)
+		bodyRewrite.metrics.addBudgetExceeded(exceeded.reason)
+	}
+
+	bodyRewrite.passThrough(state, skipBudget)
+}
+
+// passThrough write the upstream response unchanged, describing why when debug is set.
+func (bodyRewrite *rewriteBody) passThrough(state *requestState, reason string) {
+	state.summary.skipReason = reason
+
+	if state.debug {
+		state.writer.SetDiagnostic(bodyRewrite.diagnostic.name, skippedDiagnostic(reason))
+	}
+
+	// This could "error" if writing is not supported but content will return properly.
+	if err := state.writer.WriteBuffer(); err != nil {
+		bodyRewrite.recordError(state, "Unable to write original content", err)
+	}
+}
+
+// rewriteContent apply every rule group enabled for the selected variant in order.
+func (bodyRewrite *rewriteBody) rewriteContent(state *requestState, bodyBytes []byte) ([]byte, []ruleMatch, error) {
+	requestLogger := state.logger
+
+	recorder := &ruleRecorder{
+		matches:      make([]ruleMatch, 0),
+		deadline:     state.deadline,
+		maxRuleInput: bodyRewrite.budget.maxRuleInput,
+	}
+
+	if bodyRewrite.diffOptions != nil && requestLogger.Enabled(logger.Debug) {
+		recorder.onStep = func(rule *rewrite, matches int, before, after []byte) {
+			requestLogger.Debugw(
+				"Rule changed body",
+				"rule", rule.index,
+				"name", rule.name,
+				"matches", matches,
+				"diff", requestLogger.FormatBody([]byte(unifiedDiff(before, after, bodyRewrite.diffOptions))),
+			)
+		}
+	}
+
+	for i := range bodyRewrite.ruleGroups {
+		group := &bodyRewrite.ruleGroups[i]
+		if !bodyRewrite.rollout.enabled(group, state.variant) {
+			continue
+		}
+
+		var err error
+		if bodyBytes, err = group.apply(bodyBytes, recorder); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return bodyBytes, recorder.matches, nil
+}
+
+// maskedConfig serialize config for logging with secrets replaced.
+func maskedConfig(config *Config) []byte {
+	masked := *config
+	if masked.DebugHeader.Secret != "" {
+		masked.DebugHeader.Secret = maskedValue
+	}
+
+	if masked.Capture.TriggerSecret != "" {
+		masked.Capture.TriggerSecret = maskedValue
 	}
 
-	bodyRewrite.logger.LogDebugf("Transformed body: %s", bodyBytes)
+	data, _ := json.Marshal(masked)
 
-	encoding := wrappedWriter.Header().Get("Content-Encoding")
-	wrappedWriter.SetContent(bodyBytes, encoding)
+	return data
 }
 
 func (bodyRewrite *rewriteBody) handlePanic() {
@@ -139,4 +612,5 @@ func (bodyRewrite *rewriteBody) logError(err error) {
 	}
 
 	bodyRewrite.logger.LogWarningf("Recovered from: %v", err)
+	bodyRewrite.recentErrors.add(recentError{Time: time.Now().UTC(), Message: "Recovered from panic", Error: err.Error()})
 }
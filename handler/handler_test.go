@@ -3,14 +3,24 @@ package handler
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/packruler/rewrite-body/compressutil"
 	"github.com/packruler/rewrite-body/httputil"
+	"github.com/packruler/rewrite-body/logger"
+	"github.com/packruler/rewrite-body/tracing"
 )
 
 func TestServeHTTP(t *testing.T) {
@@ -19,6 +29,8 @@ func TestServeHTTP(t *testing.T) {
 		contentEncoding string
 		contentType     string `default:"text/html"`
 		rewrites        []Rewrite
+		ruleGroups      []RuleGroup
+		mode            string
 		lastModified    bool
 		resBody         string
 		expResBody      string
@@ -52,6 +64,108 @@ func TestServeHTTP(t *testing.T) {
 			resBody:     "foo is the new bar",
 			expResBody:  "foo is the new foo",
 		},
+		{
+			desc: "should stop after the first matching rule in a first-match group",
+			ruleGroups: []RuleGroup{
+				{
+					Name: "first",
+					Mode: GroupFirstMatch,
+					Rewrites: []Rewrite{
+						{
+							Regex:       "baz",
+							Replacement: "qux",
+						},
+						{
+							Regex:       "foo",
+							Replacement: "bar",
+						},
+						{
+							Regex:       "bar",
+							Replacement: "foo",
+						},
+					},
+				},
+			},
+			contentType: "text/html",
+			resBody:     "foo is the new bar",
+			expResBody:  "bar is the new bar",
+		},
+		{
+			desc: "should match every rule against the original body in a parallel group",
+			ruleGroups: []RuleGroup{
+				{
+					Name: "swap",
+					Mode: GroupParallel,
+					Rewrites: []Rewrite{
+						{
+							Regex:       "foo",
+							Replacement: "bar",
+						},
+						{
+							Regex:       "bar",
+							Replacement: "foo",
+						},
+						{
+							Regex:       "(new) ba",
+							Replacement: "${1}est ba",
+						},
+					},
+				},
+			},
+			contentType: "text/html",
+			resBody:     "foo is the new bar",
+			expResBody:  "bar is the newest bar",
+		},
+		{
+			desc: "should apply rule groups after top level rewrites",
+			rewrites: []Rewrite{
+				{
+					Regex:       "foo",
+					Replacement: "bar",
+				},
+			},
+			ruleGroups: []RuleGroup{
+				{
+					Name: "second",
+					Rewrites: []Rewrite{
+						{
+							Regex:       "bar is",
+							Replacement: "baz is",
+						},
+					},
+				},
+			},
+			contentType: "text/html",
+			resBody:     "foo is the new bar",
+			expResBody:  "baz is the new bar",
+		},
+		{
+			desc: "should not replace anything in shadow mode",
+			rewrites: []Rewrite{
+				{
+					Regex:       "foo",
+					Replacement: "bar",
+				},
+			},
+			mode:        ModeShadow,
+			contentType: "text/html",
+			resBody:     "foo is the new bar",
+			expResBody:  "foo is the new bar",
+		},
+		{
+			desc: "should write original encoded content in shadow mode",
+			rewrites: []Rewrite{
+				{
+					Regex:       "foo",
+					Replacement: "bar",
+				},
+			},
+			mode:            ModeShadow,
+			contentEncoding: "gzip",
+			contentType:     "text/html",
+			resBody:         compressString("foo is the new bar", "gzip"),
+			expResBody:      compressString("foo is the new bar", "gzip"),
+		},
 		{
 			desc: "should not replace anything if content encoding is not identity or empty",
 			rewrites: []Rewrite{
@@ -135,6 +249,49 @@ func TestServeHTTP(t *testing.T) {
 			expResBody:      compressString("bar is the new bar", "deflate"),
 			expLastModified: true,
 		},
+		{
+			desc: "should keep raw deflate sent by the upstream",
+			rewrites: []Rewrite{
+				{
+					Regex:       "foo",
+					Replacement: "bar",
+				},
+			},
+			contentEncoding: "deflate",
+			contentType:     "text/html",
+			resBody:         compressString("foo is the new bar", compressutil.DeflateRaw),
+			expResBody:      compressString("bar is the new bar", compressutil.DeflateRaw),
+		},
+		{
+			desc: "should support stacked encodings",
+			rewrites: []Rewrite{
+				{
+					Regex:       "foo",
+					Replacement: "bar",
+				},
+			},
+			contentEncoding: "deflate, gzip",
+			contentType:     "text/html",
+			lastModified:    true,
+			resBody:         compressString(compressString("foo is the new bar", "deflate"), "gzip"),
+			expResBody:      compressString(compressString("bar is the new bar", "deflate"), "gzip"),
+			expLastModified: true,
+		},
+		{
+			desc: "should ignore stacked encodings with an unsupported coding",
+			rewrites: []Rewrite{
+				{
+					Regex:       "foo",
+					Replacement: "bar",
+				},
+			},
+			contentEncoding: "br, gzip",
+			contentType:     "text/html",
+			lastModified:    true,
+			resBody:         compressString("foo is the new bar", "gzip"),
+			expResBody:      compressString("foo is the new bar", "gzip"),
+			expLastModified: true,
+		},
 		{
 			desc: "should ignore unsupported encoding",
 			rewrites: []Rewrite{
@@ -157,7 +314,9 @@ func TestServeHTTP(t *testing.T) {
 			config := &Config{
 				LastModified: test.lastModified,
 				Rewrites:     test.rewrites,
-				LogLevel:     -1,
+				RuleGroups:   test.ruleGroups,
+				Mode:         test.mode,
+				LogLevel:     "debug",
 			}
 
 			next := func(responseWriter http.ResponseWriter, req *http.Request) {
@@ -204,9 +363,15 @@ func compressString(value string, encoding string) string {
 
 func TestNew(t *testing.T) {
 	tests := []struct {
-		desc     string
-		rewrites []Rewrite
-		expErr   bool
+		desc       string
+		rewrites   []Rewrite
+		ruleGroups []RuleGroup
+		rollout    Rollout
+		budget     Budget
+		levels     map[string]int
+		parallel   compressutil.Parallel
+		thresholds compressutil.Thresholds
+		expErr     bool
 	}{
 		{
 			desc: "should return no error",
@@ -232,6 +397,56 @@ func TestNew(t *testing.T) {
 			},
 			expErr: true,
 		},
+		{
+			desc: "should return an error for a rollout referencing an unknown rule group",
+			ruleGroups: []RuleGroup{
+				{
+					Name: "known",
+				},
+			},
+			rollout: Rollout{
+				Variants: []Variant{
+					{Name: "test", Weight: 1, RuleGroups: []string{"unknown"}},
+				},
+			},
+			expErr: true,
+		},
+		{
+			desc: "should return an error for an unknown rule group mode",
+			ruleGroups: []RuleGroup{
+				{
+					Name: "unknown",
+					Mode: "sometimes",
+					Rewrites: []Rewrite{
+						{
+							Regex:       "foo",
+							Replacement: "bar",
+						},
+					},
+				},
+			},
+			expErr: true,
+		},
+		{
+			desc:   "should return an error for an invalid budget timeout",
+			budget: Budget{Timeout: "soon"},
+			expErr: true,
+		},
+		{
+			desc:   "should return an error for an invalid compression level",
+			levels: map[string]int{compressutil.Gzip: 42},
+			expErr: true,
+		},
+		{
+			desc:     "should return an error for negative parallel compression workers",
+			parallel: compressutil.Parallel{Threshold: 1024, Workers: -1},
+			expErr:   true,
+		},
+		{
+			desc:       "should return an error for compression savings of 100%",
+			thresholds: compressutil.Thresholds{MinSavings: 1},
+			expErr:     true,
+		},
 	}
 
 	defaultMonitoring := httputil.MonitoringConfig{
@@ -243,7 +458,14 @@ func TestNew(t *testing.T) {
 		t.Run(test.desc, func(t *testing.T) {
 			config := &Config{
 				Rewrites:   test.rewrites,
+				RuleGroups: test.ruleGroups,
+				Rollout:    test.rollout,
+				Budget:     test.budget,
 				Monitoring: defaultMonitoring,
+
+				CompressionLevels:     test.levels,
+				ParallelCompression:   test.parallel,
+				CompressionThresholds: test.thresholds,
 			}
 
 			_, err := New(context.Background(), nil, config, "rewriteBody")
@@ -253,3 +475,1425 @@ func TestNew(t *testing.T) {
 		})
 	}
 }
+
+func TestRollout(t *testing.T) {
+	ruleGroups := []RuleGroup{
+		{
+			Name: "banner",
+			Rewrites: []Rewrite{
+				{
+					Regex:       "foo",
+					Replacement: "bar",
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		desc       string
+		rollout    Rollout
+		cookie     string
+		expBody    string
+		expVariant string
+		expCookie  bool
+	}{
+		{
+			desc: "should assign and persist a variant in a cookie",
+			rollout: Rollout{
+				Variants: []Variant{
+					{Name: "control", Weight: 0},
+					{Name: "banner", Weight: 100, RuleGroups: []string{"banner"}},
+				},
+			},
+			expBody:    "bar is the new bar",
+			expVariant: "banner",
+			expCookie:  true,
+		},
+		{
+			desc: "should keep the variant from an existing cookie",
+			rollout: Rollout{
+				Variants: []Variant{
+					{Name: "control", Weight: 95},
+					{Name: "banner", Weight: 5, RuleGroups: []string{"banner"}},
+				},
+			},
+			cookie:     "banner",
+			expBody:    "bar is the new bar",
+			expVariant: "banner",
+		},
+		{
+			desc: "should not apply gated rule groups for other variants",
+			rollout: Rollout{
+				Stickiness: StickyIP,
+				Variants: []Variant{
+					{Name: "control", Weight: 100},
+					{Name: "banner", Weight: 0, RuleGroups: []string{"banner"}},
+				},
+			},
+			expBody:    "foo is the new bar",
+			expVariant: "control",
+		},
+		{
+			desc: "should assign from a request header",
+			rollout: Rollout{
+				Stickiness:    StickyHeader,
+				HeaderName:    "X-User",
+				VariantHeader: "X-Variant",
+				Variants: []Variant{
+					{Name: "banner", Weight: 1, RuleGroups: []string{"banner"}},
+				},
+			},
+			expBody:    "bar is the new bar",
+			expVariant: "banner",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				RuleGroups: ruleGroups,
+				Rollout:    test.rollout,
+				LogLevel:   "error",
+			}
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+				responseWriter.WriteHeader(http.StatusOK)
+
+				_, _ = responseWriter.Write([]byte("foo is the new bar"))
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", "text/html")
+			req.Header.Set("X-User", "user-1")
+
+			if test.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: defaultCookieName, Value: test.cookie})
+			}
+
+			rewriteBody.ServeHTTP(recorder, req)
+
+			if recorder.Body.String() != test.expBody {
+				t.Errorf("got body: %s\n wanted: %s", recorder.Body.String(), test.expBody)
+			}
+
+			variantHeader := test.rollout.VariantHeader
+			if variantHeader == "" {
+				variantHeader = defaultVariantHeader
+			}
+
+			if got := recorder.Result().Header.Get(variantHeader); got != test.expVariant {
+				t.Errorf("got variant %q, want %q", got, test.expVariant)
+			}
+
+			cookies := recorder.Result().Cookies()
+			if test.expCookie != (len(cookies) == 1 && cookies[0].Value == test.expVariant) {
+				t.Errorf("got cookies %v, want cookie %v", cookies, test.expCookie)
+			}
+		})
+	}
+}
+
+func TestDiffSample(t *testing.T) {
+	before, after := diffSample([]byte("foo is the new bar"), []byte("foo is the old bar"))
+
+	if before != `@0 "foo is the new bar"` {
+		t.Errorf("got before sample %s", before)
+	}
+
+	if after != `@0 "foo is the old bar"` {
+		t.Errorf("got after sample %s", after)
+	}
+
+	long := bytes.Repeat([]byte("a"), 1000)
+	changed := append(append([]byte{}, long...), 'b')
+
+	before, after = diffSample(long, changed)
+
+	if before != fmt.Sprintf("@%d %q", 1000-diffSampleContext, long[:diffSampleContext]) {
+		t.Errorf("got before sample %s", before)
+	}
+
+	if after != fmt.Sprintf("@%d %q", 1000-diffSampleContext, string(long[:diffSampleContext])+"b") {
+		t.Errorf("got after sample %s", after)
+	}
+}
+
+func TestDiagnosticHeader(t *testing.T) {
+	tests := []struct {
+		desc        string
+		debugHeader DebugHeader
+		accept      string
+		contentType string
+		secret      string
+		status      int
+		expHeader   string
+	}{
+		{
+			desc:        "should describe applied rewrites",
+			debugHeader: DebugHeader{Enabled: true},
+			accept:      "text/html",
+			contentType: "text/html",
+			status:      http.StatusNotFound,
+			expHeader:   "applied; rules=0; matches=1; decoded=identity; ms=",
+		},
+		{
+			desc:        "should describe skipped responses",
+			debugHeader: DebugHeader{Enabled: true},
+			accept:      "text/html",
+			contentType: "image/png",
+			status:      http.StatusOK,
+			expHeader:   "skipped; reason=content-type",
+		},
+		{
+			desc:        "should describe skipped requests",
+			debugHeader: DebugHeader{Enabled: true},
+			accept:      "image/png",
+			contentType: "text/html",
+			status:      http.StatusOK,
+			expHeader:   "skipped; reason=accept",
+		},
+		{
+			desc:        "should require a matching secret when configured",
+			debugHeader: DebugHeader{Enabled: true, Secret: "s3cret"},
+			accept:      "text/html",
+			contentType: "text/html",
+			secret:      "guess",
+			status:      http.StatusOK,
+			expHeader:   "",
+		},
+		{
+			desc:        "should add the header with a matching secret",
+			debugHeader: DebugHeader{Enabled: true, Name: "X-Debug", Secret: "s3cret"},
+			accept:      "text/html",
+			contentType: "image/png",
+			secret:      "s3cret",
+			status:      http.StatusOK,
+			expHeader:   "skipped; reason=content-type",
+		},
+		{
+			desc:        "should not add the header when disabled",
+			accept:      "text/html",
+			contentType: "text/html",
+			status:      http.StatusOK,
+			expHeader:   "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Rewrites: []Rewrite{
+					{
+						Regex:       "foo",
+						Replacement: "bar",
+					},
+				},
+				DebugHeader: test.debugHeader,
+				LogLevel:    "error",
+			}
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", test.contentType)
+				responseWriter.WriteHeader(test.status)
+
+				_, _ = responseWriter.Write([]byte("foo is the new bar"))
+			}
+
+			rewriteBody, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", test.accept)
+			req.Header.Set(defaultDebugSecretHeader, test.secret)
+
+			rewriteBody.ServeHTTP(recorder, req)
+
+			headerName := test.debugHeader.Name
+			if headerName == "" {
+				headerName = defaultDebugHeaderName
+			}
+
+			got := recorder.Result().Header.Get(headerName)
+			if !strings.HasPrefix(got, test.expHeader) || (test.expHeader == "" && got != "") {
+				t.Errorf("got header %q, want %q", got, test.expHeader)
+			}
+
+			if recorder.Code != test.status {
+				t.Errorf("got status %d, want %d", recorder.Code, test.status)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	diffContext := 1
+	options := newDiffOptions(DiffLog{Enabled: true, Context: &diffContext})
+
+	tests := []struct {
+		desc     string
+		before   string
+		after    string
+		expected string
+	}{
+		{
+			desc:     "should describe a single changed line",
+			before:   "a\nb\nc\nd\ne\n",
+			after:    "a\nb\nC\nd\ne\n",
+			expected: "@@ -2,3 +2,3 @@\n b\n-c\n+C\n d\n",
+		},
+		{
+			desc:   "should split distant changes into hunks",
+			before: "a\nb\nc\nd\ne\nf\ng\n",
+			after:  "A\nb\nc\nd\ne\nf\nG\n",
+			expected: "@@ -1,2 +1,2 @@\n-a\n+A\n b\n" +
+				"@@ -6,2 +6,2 @@\n f\n-g\n+G\n",
+		},
+		{
+			desc:     "should describe added lines",
+			before:   "a\nb\n",
+			after:    "a\nnew\nb\n",
+			expected: "@@ -1,2 +1,3 @@\n a\n+new\n b\n",
+		},
+		{
+			desc:     "should be empty without changes",
+			before:   "a\nb\n",
+			after:    "a\nb\n",
+			expected: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got := unifiedDiff([]byte(test.before), []byte(test.after), options)
+			if got != test.expected {
+				t.Errorf("got diff:\n%s\nwanted:\n%s", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffContext(t *testing.T) {
+	noContext := 0
+
+	tests := []struct {
+		desc     string
+		context  *int
+		expected string
+	}{
+		{
+			desc:     "should default to 3 lines of context when unset",
+			expected: "@@ -2,7 +2,7 @@\n b\n c\n d\n-e\n+E\n f\n g\n h\n",
+		},
+		{
+			desc:     "should only describe changed lines with no context",
+			context:  &noContext,
+			expected: "@@ -5,1 +5,1 @@\n-e\n+E\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			options := newDiffOptions(DiffLog{Enabled: true, Context: test.context})
+
+			got := unifiedDiff([]byte("a\nb\nc\nd\ne\nf\ng\nh\ni\n"), []byte("a\nb\nc\nd\nE\nf\ng\nh\ni\n"), options)
+			if got != test.expected {
+				t.Errorf("got diff:\n%s\nwanted:\n%s", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffMaxLines(t *testing.T) {
+	diffContext := 1
+	options := newDiffOptions(DiffLog{Enabled: true, Context: &diffContext, MaxLines: 5})
+
+	before := strings.Repeat("a\n", 50)
+	after := strings.Repeat("b\n", 50)
+
+	got := unifiedDiff([]byte(before), []byte(after), options)
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+
+	// MaxLines lines of the single hunk, including its header, and the truncation notice.
+	if len(lines) != 6 {
+		t.Fatalf("got %d lines:\n%s", len(lines), got)
+	}
+
+	if lines[0] != "@@ -1,50 +1,50 @@" || lines[5] != "... diff truncated" {
+		t.Errorf("got diff:\n%s", got)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	tests := []struct {
+		desc         string
+		capture      Capture
+		redaction    logger.RedactionConfig
+		requests     int
+		triggered    int
+		expEntries   int
+		expNewest    string
+		expOriginal  string
+		expRewritten string
+	}{
+		{
+			desc:       "should capture 1 in sampleRate requests",
+			capture:    Capture{Enabled: true, SampleRate: 2},
+			requests:   5,
+			expEntries: 2,
+			expNewest:  "/3",
+		},
+		{
+			desc:       "should always capture triggered requests",
+			capture:    Capture{Enabled: true, TriggerHeader: "X-Capture", TriggerSecret: "s3cret"},
+			requests:   3,
+			triggered:  2,
+			expEntries: 2,
+			expNewest:  "/1",
+		},
+		{
+			desc:       "should ignore the trigger header without the secret",
+			capture:    Capture{Enabled: true, SampleRate: 3, TriggerHeader: "X-Capture", TriggerSecret: "other"},
+			requests:   3,
+			triggered:  2,
+			expEntries: 1,
+			expNewest:  "/2",
+		},
+		{
+			desc:         "should redact captured bodies",
+			capture:      Capture{Enabled: true, SampleRate: 1},
+			redaction:    logger.RedactionConfig{Patterns: []string{"new"}},
+			requests:     1,
+			expEntries:   1,
+			expNewest:    "/0",
+			expRewritten: "bar is the [REDACTED] bar /0",
+		},
+		{
+			desc:       "should keep at most maxEntries",
+			capture:    Capture{Enabled: true, SampleRate: 1, MaxEntries: 3},
+			requests:   5,
+			expEntries: 3,
+			expNewest:  "/4",
+		},
+		{
+			desc:        "should truncate bodies to maxBodySize",
+			capture:     Capture{Enabled: true, SampleRate: 1, MaxBodySize: 3},
+			requests:    1,
+			expEntries:  1,
+			expNewest:   "/0",
+			expOriginal: "foo",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Rewrites: []Rewrite{
+					{
+						Regex:       "foo",
+						Replacement: "bar",
+					},
+				},
+				Capture:      test.capture,
+				LogRedaction: test.redaction,
+				LogLevel:     "error",
+			}
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+
+				_, _ = responseWriter.Write([]byte("foo is the new bar " + req.URL.Path))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < test.requests; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/"+strconv.Itoa(i), nil)
+				req.Header.Set("Accept", "text/html")
+
+				if i < test.triggered {
+					req.Header.Set("X-Capture", "s3cret")
+				}
+
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			entries := handler.(*rewriteBody).capture.snapshot()
+			if len(entries) != test.expEntries {
+				t.Fatalf("got %d entries, want %d", len(entries), test.expEntries)
+			}
+
+			last := entries[len(entries)-1]
+			if last.URL != test.expNewest {
+				t.Errorf("got newest entry %q, want %q", last.URL, test.expNewest)
+			}
+
+			if test.expOriginal != "" && (last.OriginalBody != test.expOriginal || !last.Truncated) {
+				t.Errorf("got original %q truncated %v, want %q truncated", last.OriginalBody, last.Truncated, test.expOriginal)
+			}
+
+			if test.expRewritten != "" && last.RewrittenBody != test.expRewritten {
+				t.Errorf("got rewritten %q, want %q", last.RewrittenBody, test.expRewritten)
+			}
+
+			if test.expOriginal == "" && test.expRewritten == "" && !strings.HasPrefix(last.RewrittenBody, "bar is the new bar") {
+				t.Errorf("got rewritten %q", last.RewrittenBody)
+			}
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	config := &Config{
+		Rewrites: []Rewrite{
+			{
+				Name:        "foo",
+				Regex:       "foo",
+				Replacement: "bar",
+			},
+			{
+				Regex:       "missing",
+				Replacement: "found",
+			},
+		},
+		Metrics:  Metrics{Enabled: true},
+		LogLevel: "error",
+	}
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == defaultMetricsPath {
+			t.Error("metrics request reached the upstream handler")
+		}
+
+		responseWriter.Header().Set("Content-Type", req.URL.Query().Get("type"))
+		responseWriter.Header().Set("Content-Encoding", req.URL.Query().Get("encoding"))
+
+		_, _ = responseWriter.Write([]byte("foo is the new foo"))
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []string{"/?type=text/html", "/?type=text/html", "/?type=image/png", "/?type=text/html&encoding=gzip"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Accept", "text/html")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, metricsRequest(http.MethodGet, "127.0.0.1:1234"))
+
+	body := recorder.Body.String()
+
+	for _, line := range []string{
+		"rewrite_body_requests_total 5\n",
+		"rewrite_body_decode_errors_total{reason=\"corrupt\"} 1\n",
+		"rewrite_body_skipped_total{reason=\"decode\"} 1\n",
+		"rewrite_body_processed_total 2\n",
+		"rewrite_body_skipped_total{reason=\"accept\"} 1\n",
+		"rewrite_body_skipped_total{reason=\"content-type\"} 1\n",
+		"rewrite_body_rule_responses_total{rule=\"0\",name=\"foo\"} 2\n",
+		"rewrite_body_rule_matches_total{rule=\"0\",name=\"foo\"} 4\n",
+		"rewrite_body_rule_matches_total{rule=\"1\",name=\"\"} 0\n",
+		"rewrite_body_original_size_bytes_bucket{le=\"1024\"} 4\n",
+		"rewrite_body_processing_seconds_count 4\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+}
+
+func metricsRequest(method, remoteAddr string) *http.Request {
+	req := httptest.NewRequest(method, defaultMetricsPath, nil)
+	req.RemoteAddr = remoteAddr
+
+	return req
+}
+
+func TestMetricsAccess(t *testing.T) {
+	tests := []struct {
+		desc       string
+		metrics    Metrics
+		method     string
+		remoteAddr string
+		expStatus  int
+	}{
+		{
+			desc:       "should serve loopback clients by default",
+			metrics:    Metrics{Enabled: true},
+			method:     http.MethodGet,
+			remoteAddr: "127.0.0.1:1234",
+			expStatus:  http.StatusOK,
+		},
+		{
+			desc:       "should refuse clients outside the default networks",
+			metrics:    Metrics{Enabled: true},
+			method:     http.MethodGet,
+			remoteAddr: "192.168.1.1:1234",
+			expStatus:  http.StatusForbidden,
+		},
+		{
+			desc:       "should refuse clients outside the allowed networks",
+			metrics:    Metrics{Enabled: true, AllowedCIDRs: []string{"10.0.0.0/8"}},
+			method:     http.MethodGet,
+			remoteAddr: "127.0.0.1:1234",
+			expStatus:  http.StatusForbidden,
+		},
+		{
+			desc:       "should serve clients within the allowed networks",
+			metrics:    Metrics{Enabled: true, AllowedCIDRs: []string{"10.0.0.0/8"}},
+			method:     http.MethodHead,
+			remoteAddr: "10.1.2.3:1234",
+			expStatus:  http.StatusOK,
+		},
+		{
+			desc:       "should refuse other methods",
+			metrics:    Metrics{Enabled: true},
+			method:     http.MethodPost,
+			remoteAddr: "127.0.0.1:1234",
+			expStatus:  http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+				Metrics:  test.metrics,
+				LogLevel: "error",
+			}
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				t.Error("metrics request reached the upstream handler")
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, metricsRequest(test.method, test.remoteAddr))
+
+			if recorder.Code != test.expStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.expStatus)
+			}
+
+			hasCounters := strings.Contains(recorder.Body.String(), "rewrite_body_requests_total")
+			if test.expStatus != http.StatusOK && hasCounters {
+				t.Errorf("got counters in refused response:\n%s", recorder.Body.String())
+			}
+		})
+	}
+
+	config := &Config{Metrics: Metrics{Enabled: true, AllowedCIDRs: []string{"invalid"}}}
+
+	if _, err := New(context.Background(), http.NotFoundHandler(), config, "rewriteBody"); err == nil {
+		t.Error("expected an error for an invalid allowedCIDRs entry")
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	tests := []struct {
+		desc       string
+		admin      Admin
+		method     string
+		remoteAddr string
+		expStatus  int
+	}{
+		{
+			desc:       "should serve loopback clients by default",
+			admin:      Admin{Enabled: true},
+			remoteAddr: "127.0.0.1:1234",
+			expStatus:  http.StatusOK,
+		},
+		{
+			desc:       "should refuse clients outside the allowed networks",
+			admin:      Admin{Enabled: true, AllowedCIDRs: []string{"10.0.0.0/8"}},
+			remoteAddr: "192.168.1.1:1234",
+			expStatus:  http.StatusForbidden,
+		},
+		{
+			desc:       "should refuse methods other than GET and HEAD",
+			admin:      Admin{Enabled: true},
+			method:     http.MethodPost,
+			remoteAddr: "127.0.0.1:1234",
+			expStatus:  http.StatusMethodNotAllowed,
+		},
+		{
+			desc:       "should forward the path upstream when disabled",
+			remoteAddr: "127.0.0.1:1234",
+			expStatus:  http.StatusTeapot,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Rewrites: []Rewrite{
+					{
+						Name:        "foo",
+						Regex:       "foo",
+						Replacement: "bar",
+					},
+				},
+				DebugHeader: DebugHeader{Enabled: true, Secret: "s3cret"},
+				Capture:     Capture{Enabled: true, TriggerHeader: "X-Capture", TriggerSecret: "c4pture"},
+				Admin:       test.admin,
+				LogLevel:    "error",
+			}
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+
+				if req.URL.Path == defaultAdminPath {
+					responseWriter.WriteHeader(http.StatusTeapot)
+				}
+
+				_, _ = responseWriter.Write([]byte("foo"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", "text/html")
+			req.Header.Set("X-Capture", "c4pture")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			recorder := httptest.NewRecorder()
+			req = httptest.NewRequest(method, defaultAdminPath, nil)
+			req.RemoteAddr = test.remoteAddr
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != test.expStatus {
+				t.Fatalf("got status %d, want %d", recorder.Code, test.expStatus)
+			}
+
+			if test.expStatus == http.StatusMethodNotAllowed && recorder.Header().Get("Allow") != "GET, HEAD" {
+				t.Errorf("got Allow %q", recorder.Header().Get("Allow"))
+			}
+
+			if test.expStatus != http.StatusOK {
+				return
+			}
+
+			var state adminState
+			if err := json.Unmarshal(recorder.Body.Bytes(), &state); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(state.Rules) != 1 || state.Rules[0].Name != "foo" || state.Rules[0].Matches != 1 {
+				t.Errorf("got rules %+v", state.Rules)
+			}
+
+			if strings.Contains(string(state.Config), "s3cret") {
+				t.Errorf("config contains the debug secret: %s", state.Config)
+			}
+
+			if strings.Contains(string(state.Config), "c4pture") {
+				t.Errorf("config contains the capture secret: %s", state.Config)
+			}
+
+			if len(state.Captures) != 1 || state.Captures[0].RewrittenBody != "bar" {
+				t.Fatalf("got captures %+v", state.Captures)
+			}
+
+			if got := state.Captures[0].RequestHeaders.Get("X-Capture"); got != maskedValue {
+				t.Errorf("got captured trigger header %q", got)
+			}
+
+			if len(state.Monitoring.Types) == 0 {
+				t.Error("missing monitoring config")
+			}
+		})
+	}
+}
+
+type recordingExporter struct {
+	spans []*tracing.Span
+}
+
+func (exporter *recordingExporter) Export(spans []*tracing.Span) error {
+	exporter.spans = append(exporter.spans, spans...)
+
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracing.RegisterExporter("recording", func(tracing.Config) (tracing.Exporter, error) {
+		return exporter, nil
+	})
+
+	config := &Config{
+		Rewrites: []Rewrite{
+			{
+				Regex:       "foo",
+				Replacement: "bar",
+			},
+		},
+		Tracing:  tracing.Config{Enabled: true, Exporter: "recording"},
+		LogLevel: "error",
+	}
+
+	var upstreamParent string
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		upstreamParent = req.Header.Get(tracing.TraceparentHeader)
+
+		responseWriter.Header().Set("Content-Type", "text/html")
+		responseWriter.Header().Set("Content-Encoding", compressutil.Gzip)
+
+		body, _ := compressutil.Encode([]byte("foo"), compressutil.Gzip)
+		_, _ = responseWriter.Write(body)
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	names := make([]string, 0, len(exporter.spans))
+	for _, span := range exporter.spans {
+		names = append(names, span.Name)
+	}
+
+	if strings.Join(names, ",") != "buffer,decode,rules,encode,rewrite-body" {
+		t.Fatalf("got spans %v", names)
+	}
+
+	root := exporter.spans[len(exporter.spans)-1]
+	if root.Parent != [8]byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7} {
+		t.Errorf("root span not linked to the incoming trace")
+	}
+
+	if upstreamParent != root.Traceparent() {
+		t.Errorf("got upstream traceparent %q, want %q", upstreamParent, root.Traceparent())
+	}
+
+	if exporter.spans[2].Attributes["matches"] != 1 {
+		t.Errorf("got rules attributes %v", exporter.spans[2].Attributes)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	tests := []struct {
+		desc      string
+		budget    Budget
+		expBody   string
+		expMetric string
+	}{
+		{
+			desc:    "should rewrite within the budget",
+			budget:  Budget{Timeout: "1m", MaxRuleInput: 1024},
+			expBody: "bar is the new bar",
+		},
+		{
+			desc:      "should send the original body when the timeout is exceeded",
+			budget:    Budget{Timeout: "1ns"},
+			expBody:   "foo is the new bar",
+			expMetric: "rewrite_body_budget_exceeded_total{reason=\"timeout\"} 1\n",
+		},
+		{
+			desc:      "should send the original body when the input is too large for a rule",
+			budget:    Budget{MaxRuleInput: 8},
+			expBody:   "foo is the new bar",
+			expMetric: "rewrite_body_budget_exceeded_total{reason=\"input-size\"} 1\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Rewrites: []Rewrite{
+					{
+						Regex:       "foo",
+						Replacement: "bar",
+					},
+				},
+				Budget:      test.budget,
+				DebugHeader: DebugHeader{Enabled: true},
+				Metrics:     Metrics{Enabled: true},
+				LogLevel:    "error",
+			}
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+				responseWriter.Header().Set("Content-Encoding", compressutil.Gzip)
+
+				body, _ := compressutil.Encode([]byte("foo is the new bar"), compressutil.Gzip)
+				_, _ = responseWriter.Write(body)
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", "text/html")
+			req.Header.Set("Accept-Encoding", compressutil.Gzip)
+
+			handler.ServeHTTP(recorder, req)
+
+			body, err := compressutil.Decode(recorder.Body, compressutil.Gzip)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(body) != test.expBody {
+				t.Errorf("got body %q, want %q", body, test.expBody)
+			}
+
+			if test.expMetric == "" {
+				return
+			}
+
+			if got := recorder.Result().Header.Get(defaultDebugHeaderName); got != skippedDiagnostic(skipBudget) {
+				t.Errorf("got diagnostic %q", got)
+			}
+
+			metricsRecorder := httptest.NewRecorder()
+			handler.ServeHTTP(metricsRecorder, metricsRequest(http.MethodGet, "127.0.0.1:1234"))
+
+			if !strings.Contains(metricsRecorder.Body.String(), test.expMetric) {
+				t.Errorf("missing %q in:\n%s", test.expMetric, metricsRecorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestBudgetFindEdits(t *testing.T) {
+	body := []byte("foo is the new bar\nbar é foo")
+
+	for _, expression := range []string{"foo", "o*", "x*", "", "é?", "^bar", "(?m)^bar", `\bfoo`, "(b)(a)r$"} {
+		t.Run(expression, func(t *testing.T) {
+			rules, err := compileRewrites([]Rewrite{{Regex: expression, Replacement: "[$0]"}}, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := &ruleRecorder{deadline: time.Now().Add(time.Minute)}
+
+			edits, err := recorder.findEdits(&rules[0], body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := rules[0].findEdits(body); !reflect.DeepEqual(edits, want) {
+				t.Errorf("got edits %v, want %v", edits, want)
+			}
+		})
+	}
+}
+
+// failingCodec reads content unchanged and fails to encode it.
+type failingCodec struct{}
+
+func (failingCodec) NewReader(source io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(source), nil
+}
+
+func (failingCodec) NewWriter(io.Writer, int) (io.WriteCloser, error) {
+	return failingWriter{}, nil
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (failingWriter) Close() error {
+	return errors.New("encoder failed")
+}
+
+func TestEncodeFallback(t *testing.T) {
+	// Registered for the rest of the test binary under a name no other test negotiates.
+	const encoding = "x-failing"
+
+	compressutil.Register(encoding, failingCodec{})
+
+	tests := []struct {
+		desc        string
+		fallback    string
+		flush       bool
+		expBody     string
+		expEncoding string
+		expVary     string
+	}{
+		{
+			desc:     "should send the rewritten body as identity",
+			fallback: FallbackIdentity,
+			expBody:  "bar is the new bar",
+			expVary:  "Accept-Encoding",
+		},
+		{
+			desc:        "should send the original response",
+			fallback:    FallbackOriginal,
+			expBody:     "foo is the new bar",
+			expEncoding: encoding,
+		},
+		{
+			desc:        "should send the original response when the header was flushed",
+			fallback:    FallbackIdentity,
+			flush:       true,
+			expBody:     "foo is the new bar",
+			expEncoding: encoding,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{
+				Rewrites: []Rewrite{
+					{
+						Regex:       "foo",
+						Replacement: "bar",
+					},
+				},
+				EncodeFallback: test.fallback,
+				Metrics:        Metrics{Enabled: true},
+				LogLevel:       "error",
+			}
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+				responseWriter.Header().Set("Content-Encoding", encoding)
+
+				_, _ = responseWriter.Write([]byte("foo is the new bar"))
+
+				if flusher, ok := responseWriter.(http.Flusher); ok && test.flush {
+					flusher.Flush()
+				}
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept", "text/html")
+			req.Header.Set("Accept-Encoding", encoding)
+
+			handler.ServeHTTP(recorder, req)
+
+			result := recorder.Result()
+
+			if recorder.Body.String() != test.expBody {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expBody)
+			}
+
+			if got := result.Header.Get("Content-Encoding"); got != test.expEncoding {
+				t.Errorf("got Content-Encoding %q, want %q", got, test.expEncoding)
+			}
+
+			if got := result.Header.Get("Vary"); got != test.expVary {
+				t.Errorf("got Vary %q, want %q", got, test.expVary)
+			}
+
+			metricsRecorder := httptest.NewRecorder()
+			handler.ServeHTTP(metricsRecorder, metricsRequest(http.MethodGet, "127.0.0.1:1234"))
+
+			if !strings.Contains(metricsRecorder.Body.String(), "rewrite_body_encode_errors_total 1\n") {
+				t.Errorf("missing encode error in:\n%s", metricsRecorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	large := bytes.Repeat([]byte("foo "), 10000)
+
+	gzipped, err := compressutil.Encode(large, compressutil.Gzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{
+		Rewrites: []Rewrite{
+			{
+				Regex:       "foo",
+				Replacement: "bar",
+			},
+		},
+		DecodeLimits: compressutil.Limits{MaxSize: 1024},
+		DebugHeader:  DebugHeader{Enabled: true},
+		LogLevel:     "error",
+	}
+
+	next := func(responseWriter http.ResponseWriter, req *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/html")
+		responseWriter.Header().Set("Content-Encoding", compressutil.Gzip)
+
+		_, _ = responseWriter.Write(gzipped)
+	}
+
+	handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Accept-Encoding", compressutil.Gzip)
+
+	handler.ServeHTTP(recorder, req)
+
+	if !bytes.Equal(recorder.Body.Bytes(), gzipped) {
+		t.Error("expected the original compressed body")
+	}
+
+	if got := recorder.Result().Header.Get("Content-Encoding"); got != compressutil.Gzip {
+		t.Errorf("got Content-Encoding %q, want %q", got, compressutil.Gzip)
+	}
+
+	if got := recorder.Result().Header.Get(defaultDebugHeaderName); got != skippedDiagnostic(skipDecodeLimit) {
+		t.Errorf("got diagnostic %q", got)
+	}
+}
+
+// decodeMap assign input to the fields of target named by their json tag, like the mapstructure decoding
+// Traefik applies to plugin configuration. Unlike encoding/json it never calls UnmarshalText or UnmarshalJSON.
+func decodeMap(t *testing.T, input interface{}, target reflect.Value) {
+	t.Helper()
+
+	switch target.Kind() {
+	case reflect.Struct:
+		values, ok := input.(map[string]interface{})
+		if !ok {
+			t.Fatalf("cannot decode %T into %s", input, target.Type())
+		}
+
+		for i := 0; i < target.NumField(); i++ {
+			name := strings.Split(target.Type().Field(i).Tag.Get("json"), ",")[0]
+			if value, ok := values[name]; ok {
+				decodeMap(t, value, target.Field(i))
+			}
+		}
+	case reflect.Slice:
+		values, ok := input.([]interface{})
+		if !ok {
+			t.Fatalf("cannot decode %T into %s", input, target.Type())
+		}
+
+		target.Set(reflect.MakeSlice(target.Type(), len(values), len(values)))
+
+		for i, value := range values {
+			decodeMap(t, value, target.Index(i))
+		}
+	case reflect.Map:
+		values, ok := input.(map[string]interface{})
+		if !ok {
+			t.Fatalf("cannot decode %T into %s", input, target.Type())
+		}
+
+		target.Set(reflect.MakeMap(target.Type()))
+
+		for key, value := range values {
+			element := reflect.New(target.Type().Elem()).Elem()
+			decodeMap(t, value, element)
+			target.SetMapIndex(reflect.ValueOf(key), element)
+		}
+	default:
+		value := reflect.ValueOf(input)
+		if !value.Type().AssignableTo(target.Type()) {
+			t.Fatalf("cannot decode %T into %s", input, target.Type())
+		}
+
+		target.Set(value)
+	}
+}
+
+func TestLogLevelDecoding(t *testing.T) {
+	tests := []struct {
+		desc   string
+		input  map[string]interface{}
+		expErr bool
+	}{
+		{
+			desc: "should accept level names",
+			input: map[string]interface{}{
+				"logLevel":  "debug",
+				"logLevels": map[string]interface{}{"compress": "trace"},
+				"logSinks": []interface{}{
+					map[string]interface{}{"type": "discard", "levels": []interface{}{"trace", "debug"}},
+				},
+			},
+		},
+		{
+			desc:  "should accept level numbers as strings",
+			input: map[string]interface{}{"logLevel": "-1", "logLevels": map[string]interface{}{"compress": "-2"}},
+		},
+		{
+			desc:   "should reject an unknown level",
+			input:  map[string]interface{}{"logLevel": "verbose"},
+			expErr: true,
+		},
+		{
+			desc: "should reject an unknown sink level",
+			input: map[string]interface{}{
+				"logSinks": []interface{}{map[string]interface{}{"type": "discard", "levels": []interface{}{"loud"}}},
+			},
+			expErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			config := &Config{}
+			decodeMap(t, test.input, reflect.ValueOf(config).Elem())
+
+			logWriter, err := createLogger(config, "test")
+			if test.expErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !logWriter.Enabled(logger.Debug) || logWriter.Enabled(logger.Trace) {
+				t.Error("expected the debug level")
+			}
+
+			compressLogger := logWriter.WithSubsystem(logger.SubsystemCompress)
+			if !compressLogger.Enabled(logger.Trace) {
+				t.Error("expected the trace level for the compress subsystem")
+			}
+		})
+	}
+}
+
+func TestSummary(t *testing.T) {
+	tests := []struct {
+		desc      string
+		method    string
+		accept    string
+		expFields map[string]interface{}
+	}{
+		{
+			desc:   "should summarize a rewritten request",
+			method: http.MethodGet,
+			accept: "text/html",
+			expFields: map[string]interface{}{
+				"status":        float64(http.StatusOK),
+				"contentType":   "text/html",
+				"originalSize":  float64(18),
+				"rewrittenSize": float64(18),
+				"encodedSize":   float64(18),
+				"rules":         "[0]",
+				"matches":       float64(1),
+				"skipReason":    "",
+				"path":          "/page",
+			},
+		},
+		{
+			desc:   "should summarize a request skipped by method",
+			method: http.MethodPost,
+			accept: "text/html",
+			expFields: map[string]interface{}{
+				"status":      float64(http.StatusOK),
+				"contentType": "text/html",
+				"encodedSize": float64(18),
+				"skipReason":  httputil.SkipMethod,
+			},
+		},
+		{
+			desc:   "should summarize a request skipped by accept",
+			method: http.MethodGet,
+			accept: "image/png",
+			expFields: map[string]interface{}{
+				"status":     float64(http.StatusOK),
+				"skipReason": httputil.SkipAccept,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "summary.log")
+
+			config := &Config{
+				Rewrites:  []Rewrite{{Regex: "foo", Replacement: "bar"}},
+				LogFormat: logger.FormatJSON,
+				LogSinks:  []logger.SinkConfig{{Type: logger.SinkFile, Path: path, Levels: []string{"info"}}},
+			}
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				responseWriter.Header().Set("Content-Type", "text/html")
+				responseWriter.WriteHeader(http.StatusOK)
+				_, _ = responseWriter.Write([]byte("foo is the new bar"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "summary")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(test.method, "/page", nil)
+			req.Header.Set("Accept", test.accept)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			summary := findLogEntry(t, path, "Request summary")
+
+			for key, expected := range test.expFields {
+				if value, ok := summary[key]; !ok || fmt.Sprint(value) != fmt.Sprint(expected) {
+					t.Errorf("got %s %v, want %v", key, summary[key], expected)
+				}
+			}
+		})
+	}
+}
+
+// findLogEntry the first JSON log entry in the file at path with message.
+func findLogEntry(t *testing.T, path, message string) map[string]interface{} {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+
+		if entry["msg"] == message {
+			return entry
+		}
+	}
+
+	t.Fatalf("no %q entry in:\n%s", message, data)
+
+	return nil
+}
+
+func TestUnsupportedWriter(t *testing.T) {
+	for _, level := range []string{"info", "error"} {
+		t.Run(level, func(t *testing.T) {
+			config := &Config{
+				Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}},
+				LogLevel: level,
+			}
+
+			recorder := httptest.NewRecorder()
+
+			next := func(responseWriter http.ResponseWriter, req *http.Request) {
+				if level != "info" && responseWriter != http.ResponseWriter(recorder) {
+					t.Errorf("got writer %T, want the original writer", responseWriter)
+				}
+
+				unwrapper, ok := responseWriter.(interface{ Unwrap() http.ResponseWriter })
+				if level == "info" && (!ok || unwrapper.Unwrap() != http.ResponseWriter(recorder)) {
+					t.Errorf("got writer %T without Unwrap to the original writer", responseWriter)
+				}
+
+				_, _ = io.Copy(responseWriter, strings.NewReader("foo is the new bar"))
+			}
+
+			handler, err := New(context.Background(), http.HandlerFunc(next), config, "rewriteBody")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", nil))
+
+			if recorder.Body.String() != "foo is the new bar" {
+				t.Errorf("got body %q", recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestStatsWriterReadFrom(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	stats := &statsWriter{ResponseWriter: recorder}
+
+	written, err := stats.ReadFrom(strings.NewReader("foo is the new bar"))
+	if err != nil || written != 18 {
+		t.Fatalf("got %d, %v", written, err)
+	}
+
+	if stats.BytesWritten() != 18 || stats.StatusCode() != http.StatusOK || recorder.Body.String() != "foo is the new bar" {
+		t.Errorf("got %d bytes, status %d, body %q", stats.BytesWritten(), stats.StatusCode(), recorder.Body.String())
+	}
+}
+
+func TestListFormat(t *testing.T) {
+	config := &Config{
+		RuleGroups: []RuleGroup{
+			{Name: "a", Rewrites: []Rewrite{{Regex: "foo", Replacement: "bar"}}},
+			{Name: "b", Rewrites: []Rewrite{{Regex: "bar", Replacement: "baz"}}},
+		},
+		Rollout: Rollout{
+			Variants: []Variant{{Name: "both", Weight: 1, RuleGroups: []string{"║24║a║b"}}},
+		},
+		LogRedaction: logger.RedactionConfig{
+			Patterns: []string{"║24║secret║token"},
+			Headers:  []string{"║24║Authorization║X-Api-Key"},
+		},
+		LogSinks: []logger.SinkConfig{{Type: logger.SinkDiscard, Levels: []string{"║24║info║error"}}},
+		Metrics:  Metrics{Enabled: true, AllowedCIDRs: []string{"║24║10.0.0.0/8║127.0.0.0/8"}},
+		Admin:    Admin{Enabled: true, AllowedCIDRs: []string{"║24║10.0.0.0/8║127.0.0.0/8"}},
+	}
+
+	if _, err := New(context.Background(), http.NotFoundHandler(), config, "rewriteBody"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, values := range map[string][]string{
+		"rollout ruleGroups":  config.Rollout.Variants[0].RuleGroups,
+		"redaction patterns":  config.LogRedaction.Patterns,
+		"redaction headers":   config.LogRedaction.Headers,
+		"log sink levels":     config.LogSinks[0].Levels,
+		"metrics allowedCIDR": config.Metrics.AllowedCIDRs,
+		"admin allowedCIDRs":  config.Admin.AllowedCIDRs,
+	} {
+		if len(values) != 2 {
+			t.Errorf("got %s %q, want 2 items", name, values)
+		}
+	}
+}
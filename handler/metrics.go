@@ -0,0 +1,285 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/packruler/rewrite-body/compressutil"
+)
+
+// defaultMetricsPath served by the middleware when Metrics is enabled without a Path.
+const defaultMetricsPath = "/__rewrite-body/metrics"
+
+var (
+	// latencyBuckets upper bounds in seconds of the processing latency histogram.
+	latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+	// sizeBuckets upper bounds in bytes of the body size histograms.
+	sizeBuckets = []float64{1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216}
+)
+
+// histogram a fixed bucket distribution of observed values.
+type histogram struct {
+	lock    sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (hist *histogram) observe(value float64) {
+	hist.lock.Lock()
+	defer hist.lock.Unlock()
+
+	for index, bound := range hist.buckets {
+		if value <= bound {
+			hist.counts[index]++
+		}
+	}
+
+	hist.sum += value
+	hist.count++
+}
+
+// write the histogram in Prometheus text format with cumulative buckets.
+func (hist *histogram) write(writer io.Writer, name, help string) {
+	hist.lock.Lock()
+	defer hist.lock.Unlock()
+
+	fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+
+	for index, bound := range hist.buckets {
+		fmt.Fprintf(writer, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), hist.counts[index])
+	}
+
+	fmt.Fprintf(writer, "%s_bucket{le=\"+Inf\"} %d\n", name, hist.count)
+	fmt.Fprintf(writer, "%s_sum %s\n", name, formatFloat(hist.sum))
+	fmt.Fprintf(writer, "%s_count %d\n", name, hist.count)
+}
+
+// ruleCounter the number of responses and replacements a single rule matched.
+type ruleCounter struct {
+	index     int
+	name      string
+	responses uint64
+	matches   uint64
+}
+
+// metrics counters and histograms describing every request seen by a rewriteBody.
+type metrics struct {
+	requests     uint64
+	processed    uint64
+	encodeErrors uint64
+
+	decodeErrorsLock sync.Mutex
+	decodeErrors     map[string]uint64
+
+	budgetTimeouts  uint64
+	budgetInputSize uint64
+
+	skippedLock sync.Mutex
+	skipped     map[string]uint64
+
+	rules []ruleCounter
+
+	latency       *histogram
+	originalSize  *histogram
+	rewrittenSize *histogram
+}
+
+func newMetrics(groups []ruleGroup) *metrics {
+	result := &metrics{
+		skipped:       make(map[string]uint64),
+		decodeErrors:  make(map[string]uint64),
+		latency:       newHistogram(latencyBuckets),
+		originalSize:  newHistogram(sizeBuckets),
+		rewrittenSize: newHistogram(sizeBuckets),
+	}
+
+	for _, group := range groups {
+		for _, rule := range group.rewrites {
+			result.rules = append(result.rules, ruleCounter{index: rule.index, name: rule.name})
+		}
+	}
+
+	sort.Slice(result.rules, func(i, j int) bool { return result.rules[i].index < result.rules[j].index })
+
+	return result
+}
+
+func (stats *metrics) addSkipped(reason string) {
+	stats.skippedLock.Lock()
+	stats.skipped[reason]++
+	stats.skippedLock.Unlock()
+}
+
+// addDecodeError count a decode failure by the kind of compressutil error.
+func (stats *metrics) addDecodeError(err error) {
+	reason := decodeErrorReason(err)
+
+	stats.decodeErrorsLock.Lock()
+	stats.decodeErrors[reason]++
+	stats.decodeErrorsLock.Unlock()
+}
+
+func (stats *metrics) addBudgetExceeded(reason string) {
+	if reason == budgetTimeout {
+		atomic.AddUint64(&stats.budgetTimeouts, 1)
+	} else {
+		atomic.AddUint64(&stats.budgetInputSize, 1)
+	}
+}
+
+// addMatches count the rules that changed a response. Rules are stored by index.
+func (stats *metrics) addMatches(matches []ruleMatch) {
+	for _, match := range matches {
+		if match.matches == 0 || match.index >= len(stats.rules) {
+			continue
+		}
+
+		atomic.AddUint64(&stats.rules[match.index].responses, 1)
+		atomic.AddUint64(&stats.rules[match.index].matches, uint64(match.matches))
+	}
+}
+
+// observe record the outcome of a request that reached the upstream handler.
+func (stats *metrics) observe(state *requestState) {
+	if state.summary.skipReason != "" {
+		stats.addSkipped(state.summary.skipReason)
+	} else {
+		atomic.AddUint64(&stats.processed, 1)
+		stats.rewrittenSize.observe(float64(state.summary.rewrittenSize))
+	}
+
+	stats.originalSize.observe(float64(state.summary.originalSize))
+	stats.latency.observe(time.Since(state.start).Seconds())
+}
+
+// write every metric in Prometheus text exposition format.
+func (stats *metrics) write(writer io.Writer) {
+	writeCounter(writer, "rewrite_body_requests_total", "Requests seen by the middleware.", atomic.LoadUint64(&stats.requests))
+	writeCounter(writer, "rewrite_body_processed_total", "Responses run through the rewrite rules.", atomic.LoadUint64(&stats.processed))
+	writeCounter(writer, "rewrite_body_encode_errors_total", "Rewritten responses that could not be encoded.", atomic.LoadUint64(&stats.encodeErrors))
+
+	fmt.Fprint(writer, "# HELP rewrite_body_budget_exceeded_total Responses sent unchanged after exceeding the processing budget.\n# TYPE rewrite_body_budget_exceeded_total counter\n")
+	fmt.Fprintf(writer, "rewrite_body_budget_exceeded_total{reason=\"%s\"} %d\n", budgetTimeout, atomic.LoadUint64(&stats.budgetTimeouts))
+	fmt.Fprintf(writer, "rewrite_body_budget_exceeded_total{reason=\"%s\"} %d\n", budgetInputSize, atomic.LoadUint64(&stats.budgetInputSize))
+
+	stats.decodeErrorsLock.Lock()
+	writeReasonCounter(writer, "rewrite_body_decode_errors_total", "Responses that could not be decoded by reason.", stats.decodeErrors)
+	stats.decodeErrorsLock.Unlock()
+
+	stats.skippedLock.Lock()
+	writeReasonCounter(writer, "rewrite_body_skipped_total", "Requests left unchanged by reason.", stats.skipped)
+	stats.skippedLock.Unlock()
+
+	fmt.Fprint(writer, "# HELP rewrite_body_rule_responses_total Responses changed by each rule.\n# TYPE rewrite_body_rule_responses_total counter\n")
+
+	for i := range stats.rules {
+		rule := &stats.rules[i]
+		fmt.Fprintf(writer, "rewrite_body_rule_responses_total{%s} %d\n", ruleLabels(rule), atomic.LoadUint64(&rule.responses))
+	}
+
+	fmt.Fprint(writer, "# HELP rewrite_body_rule_matches_total Replacements made by each rule.\n# TYPE rewrite_body_rule_matches_total counter\n")
+
+	for i := range stats.rules {
+		rule := &stats.rules[i]
+		fmt.Fprintf(writer, "rewrite_body_rule_matches_total{%s} %d\n", ruleLabels(rule), atomic.LoadUint64(&rule.matches))
+	}
+
+	stats.latency.write(writer, "rewrite_body_processing_seconds", "Time spent serving requests that reached the upstream handler.")
+	stats.originalSize.write(writer, "rewrite_body_original_size_bytes", "Size of upstream response bodies as received.")
+	stats.rewrittenSize.write(writer, "rewrite_body_rewritten_size_bytes", "Size of decoded response bodies after rewriting.")
+}
+
+// serveMetrics write the metrics to allowed clients, or 403 for clients outside the allowed networks.
+func (bodyRewrite *rewriteBody) serveMetrics(response http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		response.Header().Set("Allow", "GET, HEAD")
+		http.Error(response, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	if !bodyRewrite.metricsAllowed.allowedClient(req) {
+		http.Error(response, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+		return
+	}
+
+	bodyRewrite.metrics.ServeHTTP(response, req)
+}
+
+// ServeHTTP serve the metrics in Prometheus text format.
+func (stats *metrics) ServeHTTP(response http.ResponseWriter, _ *http.Request) {
+	response.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	stats.write(response)
+}
+
+func writeCounter(writer io.Writer, name, help string, value uint64) {
+	fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+}
+
+// writeReasonCounter write a counter with a reason label, sorted by reason.
+func writeReasonCounter(writer io.Writer, name, help string, values map[string]uint64) {
+	reasons := make([]string, 0, len(values))
+	for reason := range values {
+		reasons = append(reasons, reason)
+	}
+
+	sort.Strings(reasons)
+
+	fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+
+	for _, reason := range reasons {
+		fmt.Fprintf(writer, "%s{reason=\"%s\"} %d\n", name, escapeLabel(reason), values[reason])
+	}
+}
+
+// decodeErrorReason the metric label for a decode error.
+func decodeErrorReason(err error) string {
+	switch {
+	case errors.Is(err, compressutil.ErrSizeLimit):
+		return "size-limit"
+	case errors.Is(err, compressutil.ErrTruncatedStream):
+		return "truncated"
+	case errors.Is(err, compressutil.ErrCorruptStream):
+		return "corrupt"
+	case errors.Is(err, compressutil.ErrUnsupportedEncoding):
+		return "unsupported"
+	default:
+		return "other"
+	}
+}
+
+func ruleLabels(rule *ruleCounter) string {
+	return fmt.Sprintf("rule=\"%d\",name=\"%s\"", rule.index, escapeLabel(rule.name))
+}
+
+// escapeLabel escape a label value as required by the Prometheus text format.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
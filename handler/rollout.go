@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/packruler/rewrite-body/httputil"
+)
+
+const (
+	defaultCookieName    = "rewrite-body-variant"
+	defaultVariantHeader = "X-Rewrite-Body-Variant"
+	cookieMaxAge         = 30 * 24 * time.Hour
+)
+
+type variant struct {
+	name       string
+	weight     int
+	ruleGroups map[string]bool
+}
+
+type rollout struct {
+	stickiness    string
+	cookieName    string
+	headerName    string
+	variantHeader string
+	variants      []variant
+	totalWeight   int
+	// gated rule group names only applied for clients assigned to a variant referencing them.
+	gated map[string]bool
+}
+
+// newRollout validate a Rollout configuration against the compiled rule groups.
+// A nil rollout is returned when no variants are configured.
+func newRollout(config Rollout, groups []ruleGroup) (*rollout, error) {
+	if len(config.Variants) == 0 {
+		return nil, nil
+	}
+
+	result := &rollout{
+		stickiness:    config.Stickiness,
+		cookieName:    config.CookieName,
+		headerName:    config.HeaderName,
+		variantHeader: config.VariantHeader,
+		variants:      make([]variant, 0, len(config.Variants)),
+		gated:         make(map[string]bool),
+	}
+
+	if result.stickiness == "" {
+		result.stickiness = StickyCookie
+	}
+
+	if result.cookieName == "" {
+		result.cookieName = defaultCookieName
+	}
+
+	if result.variantHeader == "" {
+		result.variantHeader = defaultVariantHeader
+	}
+
+	switch result.stickiness {
+	case StickyCookie, StickyIP:
+	case StickyHeader:
+		if result.headerName == "" {
+			return nil, fmt.Errorf("rollout stickiness %q requires headerName", StickyHeader)
+		}
+	default:
+		return nil, fmt.Errorf("unknown rollout stickiness %q", config.Stickiness)
+	}
+
+	knownGroups := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		knownGroups[group.name] = true
+	}
+
+	for _, variantConfig := range config.Variants {
+		if variantConfig.Weight < 0 {
+			return nil, fmt.Errorf("rollout variant %q has negative weight", variantConfig.Name)
+		}
+
+		current := variant{
+			name:       variantConfig.Name,
+			weight:     variantConfig.Weight,
+			ruleGroups: make(map[string]bool, len(variantConfig.RuleGroups)),
+		}
+
+		for _, groupName := range variantConfig.RuleGroups {
+			if groupName == "" || !knownGroups[groupName] {
+				return nil, fmt.Errorf("rollout variant %q references unknown rule group %q", variantConfig.Name, groupName)
+			}
+
+			current.ruleGroups[groupName] = true
+			result.gated[groupName] = true
+		}
+
+		result.variants = append(result.variants, current)
+		result.totalWeight += current.weight
+	}
+
+	if result.totalWeight == 0 {
+		return nil, fmt.Errorf("rollout variants must have a total weight above zero")
+	}
+
+	return result, nil
+}
+
+// assign pick the variant for a request, persisting the choice on the response when sticky by cookie.
+func (config *rollout) assign(req *http.Request, wrappedWriter *httputil.ResponseWrapper) *variant {
+	var selected *variant
+
+	switch config.stickiness {
+	case StickyCookie:
+		selected = config.fromCookie(req)
+		if selected == nil {
+			selected = config.fromBucket(rand.Intn(config.totalWeight)) //nolint:gosec // assignment does not need crypto randomness.
+
+			wrappedWriter.SetCookie(&http.Cookie{
+				Name:     config.cookieName,
+				Value:    selected.name,
+				Path:     "/",
+				MaxAge:   int(cookieMaxAge.Seconds()),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+	case StickyHeader:
+		key := req.Header.Get(config.headerName)
+		if key == "" {
+			key = clientIP(req)
+		}
+
+		selected = config.fromBucket(hashBucket(key, config.totalWeight))
+	default:
+		selected = config.fromBucket(hashBucket(clientIP(req), config.totalWeight))
+	}
+
+	wrappedWriter.Header().Set(config.variantHeader, selected.name)
+
+	return selected
+}
+
+func (config *rollout) fromCookie(req *http.Request) *variant {
+	cookie, err := req.Cookie(config.cookieName)
+	if err != nil {
+		return nil
+	}
+
+	for i := range config.variants {
+		if config.variants[i].name == cookie.Value && config.variants[i].weight > 0 {
+			return &config.variants[i]
+		}
+	}
+
+	return nil
+}
+
+func (config *rollout) fromBucket(bucket int) *variant {
+	for i := range config.variants {
+		if bucket < config.variants[i].weight {
+			return &config.variants[i]
+		}
+
+		bucket -= config.variants[i].weight
+	}
+
+	return &config.variants[len(config.variants)-1]
+}
+
+// enabled determine if a rule group applies for the assigned variant.
+func (config *rollout) enabled(group *ruleGroup, selected *variant) bool {
+	if config == nil || !config.gated[group.name] {
+		return true
+	}
+
+	return selected != nil && selected.ruleGroups[group.name]
+}
+
+func hashBucket(key string, totalWeight int) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+
+	return int(hash.Sum32() % uint32(totalWeight))
+}
+
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,274 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+type ruleGroup struct {
+	name     string
+	mode     string
+	rewrites []rewrite
+}
+
+// edit a single replacement found while matching a rule against the original body.
+type edit struct {
+	rule        *rewrite
+	start       int
+	end         int
+	replacement []byte
+}
+
+// ruleMatch the number of replacements a single rule made while processing a body.
+type ruleMatch struct {
+	index   int
+	matches int
+}
+
+func compileRewrites(configs []Rewrite, firstIndex int) ([]rewrite, error) {
+	rewrites := make([]rewrite, len(configs))
+
+	for index, rewriteConfig := range configs {
+		regex, err := regexp.Compile(rewriteConfig.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling regex %q: %w", rewriteConfig.Regex, err)
+		}
+
+		rewrites[index] = rewrite{
+			index:       firstIndex + index,
+			name:        rewriteConfig.Name,
+			regex:       regex,
+			replacement: []byte(rewriteConfig.Replacement),
+			contextual:  isContextual(rewriteConfig.Regex),
+		}
+	}
+
+	return rewrites, nil
+}
+
+// compileRuleGroups build the ordered list of groups for a Config.
+// Top level Rewrites are kept as an unnamed chain group ahead of any configured RuleGroups.
+// Every rewrite is given an index following configuration order across all groups.
+func compileRuleGroups(config *Config) ([]ruleGroup, error) {
+	groups := make([]ruleGroup, 0, len(config.RuleGroups)+1)
+
+	if len(config.Rewrites) > 0 {
+		rewrites, err := compileRewrites(config.Rewrites, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, ruleGroup{mode: GroupChain, rewrites: rewrites})
+	}
+
+	ruleCount := len(config.Rewrites)
+
+	for _, groupConfig := range config.RuleGroups {
+		mode := groupConfig.Mode
+		if mode == "" {
+			mode = GroupChain
+		}
+
+		switch mode {
+		case GroupChain, GroupFirstMatch, GroupParallel:
+		default:
+			return nil, fmt.Errorf("unknown mode %q for rule group %q", groupConfig.Mode, groupConfig.Name)
+		}
+
+		rewrites, err := compileRewrites(groupConfig.Rewrites, ruleCount)
+		if err != nil {
+			return nil, err
+		}
+
+		ruleCount += len(rewrites)
+
+		groups = append(groups, ruleGroup{
+			name:     groupConfig.Name,
+			mode:     mode,
+			rewrites: rewrites,
+		})
+	}
+
+	return groups, nil
+}
+
+// ruleRecorder collects the results of every rule while rule groups are applied.
+type ruleRecorder struct {
+	matches []ruleMatch
+	// onStep when set receives the body before and after every rule that made replacements.
+	onStep func(rule *rewrite, matches int, before, after []byte)
+	// deadline after which no rule may run. Unlimited when zero.
+	deadline time.Time
+	// maxRuleInput bytes of body a rule may be applied to. Unlimited when zero.
+	maxRuleInput int
+}
+
+func (recorder *ruleRecorder) record(rule *rewrite, matches int, before, after []byte) {
+	recorder.matches = append(recorder.matches, ruleMatch{index: rule.index, matches: matches})
+
+	if recorder.onStep != nil && matches > 0 {
+		recorder.onStep(rule, matches, before, after)
+	}
+}
+
+// apply the group to body, reporting the replacements made by each rule to recorder.
+// A budgetError is returned when a rule exceeds the limits of the recorder.
+func (group *ruleGroup) apply(body []byte, recorder *ruleRecorder) ([]byte, error) {
+	switch group.mode {
+	case GroupFirstMatch:
+		return group.applyFirstMatch(body, recorder)
+	case GroupParallel:
+		return group.applyParallel(body, recorder)
+	default:
+		return group.applyChain(body, recorder)
+	}
+}
+
+func (group *ruleGroup) applyChain(body []byte, recorder *ruleRecorder) ([]byte, error) {
+	for i := range group.rewrites {
+		edits, err := recorder.findEdits(&group.rewrites[i], body)
+		if err != nil {
+			return nil, err
+		}
+
+		replaced := body
+
+		if len(edits) > 0 {
+			replaced = applyEdits(body, edits)
+		}
+
+		recorder.record(&group.rewrites[i], len(edits), body, replaced)
+		body = replaced
+	}
+
+	return body, nil
+}
+
+func (group *ruleGroup) applyFirstMatch(body []byte, recorder *ruleRecorder) ([]byte, error) {
+	for i := range group.rewrites {
+		edits, err := recorder.findEdits(&group.rewrites[i], body)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(edits) == 0 {
+			recorder.record(&group.rewrites[i], 0, body, body)
+
+			continue
+		}
+
+		replaced := applyEdits(body, edits)
+		recorder.record(&group.rewrites[i], len(edits), body, replaced)
+
+		if !bytes.Equal(replaced, body) {
+			return replaced, nil
+		}
+	}
+
+	return body, nil
+}
+
+// applyParallel match every rule against the original body and merge the resulting edits.
+// When edits overlap the one starting first wins, ties going to the rule listed first.
+func (group *ruleGroup) applyParallel(body []byte, recorder *ruleRecorder) ([]byte, error) {
+	edits := make([]edit, 0)
+
+	for i := range group.rewrites {
+		ruleEdits, err := recorder.findEdits(&group.rewrites[i], body)
+		if err != nil {
+			return nil, err
+		}
+
+		edits = append(edits, ruleEdits...)
+	}
+
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+
+	accepted := make([]edit, 0, len(edits))
+	byRule := make(map[*rewrite][]edit, len(group.rewrites))
+	last := 0
+
+	for _, current := range edits {
+		if current.start < last {
+			continue
+		}
+
+		accepted = append(accepted, current)
+		byRule[current.rule] = append(byRule[current.rule], current)
+		last = current.end
+	}
+
+	for i := range group.rewrites {
+		ruleEdits := byRule[&group.rewrites[i]]
+
+		// Only build the body for this rule's edits alone when a step handler needs it.
+		var after []byte
+		if recorder.onStep != nil && len(ruleEdits) > 0 {
+			after = applyEdits(body, ruleEdits)
+		}
+
+		recorder.record(&group.rewrites[i], len(ruleEdits), body, after)
+	}
+
+	return applyEdits(body, accepted), nil
+}
+
+func (rwt *rewrite) findEdits(body []byte) []edit {
+	matches := rwt.regex.FindAllSubmatchIndex(body, -1)
+	edits := make([]edit, 0, len(matches))
+
+	for _, match := range matches {
+		edits = append(edits, rwt.edit(body, match))
+	}
+
+	return edits
+}
+
+// edit build the replacement of a submatch index found in body.
+func (rwt *rewrite) edit(body []byte, match []int) edit {
+	return edit{
+		rule:        rwt,
+		start:       match[0],
+		end:         match[1],
+		replacement: rwt.regex.Expand(nil, rwt.replacement, body, match),
+	}
+}
+
+// applyEdits build a new body from non-overlapping edits sorted by start.
+func applyEdits(body []byte, edits []edit) []byte {
+	var result bytes.Buffer
+
+	last := 0
+
+	for _, current := range edits {
+		result.Write(body[last:current.start])
+		result.Write(current.replacement)
+		last = current.end
+	}
+
+	result.Write(body[last:])
+
+	return result.Bytes()
+}
+
+// matchedRules list the index of every rule that made a replacement along with the total replacements.
+func matchedRules(matches []ruleMatch) ([]int, int) {
+	rules := make([]int, 0, len(matches))
+	total := 0
+
+	for _, match := range matches {
+		if match.matches == 0 {
+			continue
+		}
+
+		rules = append(rules, match.index)
+		total += match.matches
+	}
+
+	return rules, total
+}
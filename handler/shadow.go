@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/packruler/rewrite-body/logger"
+)
+
+const (
+	// diffSampleContext bytes of unchanged content kept around the changed region of a diff sample.
+	diffSampleContext = 32
+	// diffSampleLimit maximum bytes of each side of a diff sample written to logs.
+	diffSampleLimit = 256
+)
+
+// formatRuleMatches describe per-rule match counts as "rule=matches" pairs.
+func formatRuleMatches(matches []ruleMatch) string {
+	parts := make([]string, 0, len(matches))
+
+	for _, match := range matches {
+		parts = append(parts, fmt.Sprintf("%d=%d", match.index, match.matches))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// diffSample return the region of before and after that differs, bounded by diffSampleLimit.
+func diffSample(before, after []byte) (string, string) {
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix &&
+		before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+
+	start := prefix - diffSampleContext
+	if start < 0 {
+		start = 0
+	}
+
+	return sampleRegion(before, start, len(before)-suffix), sampleRegion(after, start, len(after)-suffix)
+}
+
+func sampleRegion(body []byte, start, end int) string {
+	end += diffSampleContext
+	if end > len(body) {
+		end = len(body)
+	}
+
+	truncated := end-start > diffSampleLimit
+	if truncated {
+		end = start + diffSampleLimit
+	}
+
+	sample := string(bytes.ToValidUTF8(body[start:end], []byte("?")))
+	if truncated {
+		sample += "..."
+	}
+
+	return fmt.Sprintf("@%d %q", start, sample)
+}
+
+// reportShadow log what rewriting would have changed without altering the response.
+func reportShadow(requestLogger logger.LogWriter, before, after []byte, matches []ruleMatch) {
+	if !requestLogger.Enabled(logger.Info) {
+		return
+	}
+
+	if bytes.Equal(before, after) {
+		requestLogger.Infow("Shadow mode: no changes", "matches", formatRuleMatches(matches))
+
+		return
+	}
+
+	beforeSample, afterSample := diffSample(before, after)
+
+	requestLogger.Infow(
+		"Shadow mode: body would change",
+		"matches", formatRuleMatches(matches),
+		"original", requestLogger.FormatBody([]byte(beforeSample)),
+		"rewritten", requestLogger.FormatBody([]byte(afterSample)),
+	)
+}
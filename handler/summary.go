@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/packruler/rewrite-body/httputil"
+	"github.com/packruler/rewrite-body/logger"
+	"github.com/packruler/rewrite-body/tracing"
+)
+
+// requestState holds the state of a single request while its response is processed.
+type requestState struct {
+	logger   logger.LogWriter
+	request  *http.Request
+	writer   *httputil.ResponseWrapper
+	variant  *variant
+	debug    bool
+	captured bool
+	span     *tracing.Span
+	start    time.Time
+	deadline time.Time
+	summary  requestSummary
+}
+
+// requestSummary describes what happened to a response for the summary log line.
+type requestSummary struct {
+	contentType   string
+	encodingIn    string
+	originalSize  int
+	decodedSize   int
+	rewrittenSize int
+	rules         []int
+	matches       int
+	skipReason    string
+}
+
+// responseStats the parts of a response described by the summary line.
+type responseStats interface {
+	Header() http.Header
+	StatusCode() int
+	BytesWritten() int
+}
+
+// logSummary write a single Info level line describing the fate of a request.
+func logSummary(state *requestState, response responseStats) {
+	summary := state.summary
+
+	state.logger.Infow(
+		"Request summary",
+		"status", response.StatusCode(),
+		"contentType", summary.contentType,
+		"originalSize", summary.originalSize,
+		"decodedSize", summary.decodedSize,
+		"rewrittenSize", summary.rewrittenSize,
+		"encodedSize", response.BytesWritten(),
+		"encodingIn", summary.encodingIn,
+		"encodingOut", response.Header().Get("Content-Encoding"),
+		"rules", summary.rules,
+		"matches", summary.matches,
+		"durationMs", milliseconds(time.Since(state.start)),
+		"skipReason", summary.skipReason,
+	)
+}
+
+// statsWriter pass a response through unchanged while recording what the summary line needs.
+type statsWriter struct {
+	http.ResponseWriter
+
+	status  int
+	written int
+}
+
+func (writer *statsWriter) WriteHeader(statusCode int) {
+	if writer.status == 0 {
+		writer.status = statusCode
+	}
+
+	writer.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (writer *statsWriter) Write(data []byte) (int, error) {
+	if writer.status == 0 {
+		writer.status = http.StatusOK
+	}
+
+	written, err := writer.ResponseWriter.Write(data)
+	writer.written += written
+
+	return written, err
+}
+
+func (writer *statsWriter) StatusCode() int {
+	return writer.status
+}
+
+func (writer *statsWriter) BytesWritten() int {
+	return writer.written
+}
+
+// ReadFrom copy source to the wrapped writer, using its io.ReaderFrom when it has one.
+func (writer *statsWriter) ReadFrom(source io.Reader) (int64, error) {
+	readerFrom, ok := writer.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		// Hides this method from io.Copy so it falls back to Write.
+		return io.Copy(struct{ io.Writer }{writer}, source)
+	}
+
+	if writer.status == 0 {
+		writer.status = http.StatusOK
+	}
+
+	written, err := readerFrom.ReadFrom(source)
+	writer.written += int(written)
+
+	return written, err
+}
+
+// Unwrap the original writer for http.ResponseController.
+func (writer *statsWriter) Unwrap() http.ResponseWriter {
+	return writer.ResponseWriter
+}
+
+// CloseNotify returns a channel that receives a single value when the client connection has gone away.
+func (writer *statsWriter) CloseNotify() <-chan bool {
+	if notifier, ok := writer.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+
+	return make(<-chan bool)
+}
+
+// Push initiate an HTTP/2 server push when the wrapped writer supports it.
+func (writer *statsWriter) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := writer.ResponseWriter.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+
+	return http.ErrNotSupported
+}
+
+// Flush the wrapped writer when it supports it.
+func (writer *statsWriter) Flush() {
+	if flusher, ok := writer.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack the connection of the wrapped writer, as WebSocket upgrades require.
+func (writer *statsWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := writer.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	if writer.status == 0 {
+		writer.status = http.StatusSwitchingProtocols
+	}
+
+	return hijacker.Hijack()
+}
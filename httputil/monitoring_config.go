@@ -3,13 +3,71 @@ package httputil
 import (
 	"net/http"
 	"strings"
+
+	"github.com/packruler/rewrite-body/compressutil"
+)
+
+const (
+	// SkipAccept request Accept header does not include a monitored type.
+	SkipAccept string = "accept"
+	// SkipMethod request method is not monitored.
+	SkipMethod string = "method"
+	// SkipWebSocket request is a WebSocket upgrade.
+	SkipWebSocket string = "websocket"
+	// SkipContentType response Content-Type is not monitored.
+	SkipContentType string = "content-type"
+	// SkipEncoding response Content-Encoding is not supported.
+	SkipEncoding string = "encoding"
+	// SkipPayload response body is a compressed file, such as an application/gzip download.
+	SkipPayload string = "compressed-payload"
+	// SkipExtension request path or response filename has an excluded extension.
+	SkipExtension string = "extension"
 )
 
 // MonitoringConfig structure of data for handling configuration for
 // controlling what content is monitored.
 type MonitoringConfig struct {
-	Types   []string `json:"types,omitempty" yaml:"types,omitempty" toml:"types,omitempty" export:"true"`
-	Methods []string `json:"methods,omitempty" yaml:"methods,omitempty" toml:"methods,omitempty" export:"true"`
+	Types              []string `json:"types,omitempty" yaml:"types,omitempty" toml:"types,omitempty" export:"true"`
+	Methods            []string `json:"methods,omitempty" yaml:"methods,omitempty" toml:"methods,omitempty" export:"true"`
+	DisabledEncodings  []string `json:"disabledEncodings,omitempty" yaml:"disabledEncodings,omitempty" toml:"disabledEncodings,omitempty" export:"true"`
+	ExcludedExtensions []string `json:"excludedExtensions,omitempty" yaml:"excludedExtensions,omitempty" toml:"excludedExtensions,omitempty" export:"true"`
+}
+
+// Excludes determine if a request path or filename has one of the excluded extensions.
+func (config *MonitoringConfig) Excludes(name string) bool {
+	return hasExtension(name, config.ExcludedExtensions)
+}
+
+// Encodings list the compressutil encodings enabled by config in order of preference.
+func (config *MonitoringConfig) Encodings() []string {
+	encodings := compressutil.Encodings()
+	result := make([]string, 0, len(encodings))
+
+	for _, encoding := range encodings {
+		if config.SupportsEncoding(encoding) {
+			result = append(result, encoding)
+		}
+	}
+
+	return result
+}
+
+// SupportsEncoding determine if content with encoding can be processed.
+// Identity is always supported, other encodings need a registered codec that is not disabled.
+func (config *MonitoringConfig) SupportsEncoding(encoding string) bool {
+	if encoding == compressutil.Identity || encoding == "" {
+		return true
+	}
+
+	for _, disabled := range config.DisabledEncodings {
+		if strings.EqualFold(disabled, encoding) {
+			return false
+		}
+	}
+
+	_, ok := compressutil.Lookup(encoding)
+
+	return ok
 }
 
 // EnsureDefaults check Types and Methods for empty arrays and apply default values if found.
@@ -25,11 +83,18 @@ func (config *MonitoringConfig) EnsureDefaults() {
 
 // EnsureProperFormat handle weird yaml parsing until the underlying issue can be resolved.
 func (config *MonitoringConfig) EnsureProperFormat() {
-	if len(config.Methods) == 1 && strings.HasPrefix(config.Methods[0], "║24║") {
-		config.Methods = strings.Split(strings.ReplaceAll(config.Methods[0], "║24║", ""), "║")
-	}
+	config.Methods = ProperList(config.Methods)
+	config.Types = ProperList(config.Types)
+	config.DisabledEncodings = ProperList(config.DisabledEncodings)
+	config.ExcludedExtensions = ProperList(config.ExcludedExtensions)
+}
 
-	if len(config.Types) == 1 && strings.HasPrefix(config.Types[0], "║24║") {
-		config.Types = strings.Split(strings.ReplaceAll(config.Types[0], "║24║", ""), "║")
+// ProperList split a list Traefik delivered as a single "║24║a║b" value into its items.
+// Other lists are returned unchanged.
+func ProperList(values []string) []string {
+	if len(values) == 1 && strings.HasPrefix(values[0], "║24║") {
+		return strings.Split(strings.ReplaceAll(values[0], "║24║", ""), "║")
 	}
+
+	return values
 }
@@ -2,6 +2,7 @@ package httputil_test
 
 import (
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/packruler/rewrite-body/httputil"
@@ -57,6 +58,18 @@ func TestMonitoringConfigParsing(t *testing.T) {
 				Methods: []string{http.MethodGet},
 			},
 		},
+		{
+			desc: "handle weird yaml parsing of disabled encodings",
+			inputConfig: httputil.MonitoringConfig{
+				Types:             []string{"text/html"},
+				DisabledEncodings: []string{"║24║gzip║deflate"},
+			},
+			expectedConfig: httputil.MonitoringConfig{
+				Types:             []string{"text/html"},
+				Methods:           []string{http.MethodGet},
+				DisabledEncodings: []string{"gzip", "deflate"},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -85,6 +98,11 @@ func TestMonitoringConfigParsing(t *testing.T) {
 					t.Errorf("Expected Methods: '%v' | Got Methods: '%v'", test.expectedConfig.Methods, config.Methods)
 				}
 			}
+
+			if strings.Join(config.DisabledEncodings, ",") != strings.Join(test.expectedConfig.DisabledEncodings, ",") {
+				t.Errorf("Expected DisabledEncodings: '%v' | Got DisabledEncodings: '%v'",
+					test.expectedConfig.DisabledEncodings, config.DisabledEncodings)
+			}
 		})
 	}
 }
@@ -0,0 +1,75 @@
+package httputil
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// compressedTypes media types of files that are compressed as a whole, such as a .gz download.
+// Their body is the file itself, any Content-Encoding applies on top of it.
+var compressedTypes = []string{
+	"application/gzip",
+	"application/x-gzip",
+	"application/zlib",
+	"application/zip",
+	"application/x-compress",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/zstd",
+}
+
+// compressedExtensions of files that are compressed as a whole.
+var compressedExtensions = []string{".gz", ".tgz", ".z", ".zz", ".zip", ".bz2", ".xz", ".zst", ".br"}
+
+// isCompressedPayload determine if the response body is a compressed file rather than content
+// with a Content-Encoding, from its Content-Type or the filename in Content-Disposition.
+func isCompressedPayload(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err == nil {
+		for _, compressedType := range compressedTypes {
+			if mediaType == compressedType {
+				return true
+			}
+		}
+	}
+
+	return hasExtension(dispositionFilename(header), compressedExtensions)
+}
+
+// dispositionFilename the filename suggested by the Content-Disposition header, if any.
+func dispositionFilename(header http.Header) string {
+	_, params, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+
+	return params["filename"]
+}
+
+// hasExtension determine if name ends with one of extensions, ignoring case.
+// Extensions may be given with or without the leading dot.
+func hasExtension(name string, extensions []string) bool {
+	if name == "" {
+		return false
+	}
+
+	name = strings.ToLower(name)
+
+	for _, extension := range extensions {
+		extension = strings.ToLower(strings.TrimSpace(extension))
+		if extension == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(extension, ".") {
+			extension = "." + extension
+		}
+
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+
+	return false
+}
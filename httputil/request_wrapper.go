@@ -40,7 +40,7 @@ func (req *RequestWrapper) CloneNoEncode() *http.Request {
 func (req *RequestWrapper) CloneWithSupportedEncoding() *http.Request {
 	clonedRequest := req.Clone(req.Context())
 
-	clonedRequest.Header.Set("Accept-Encoding", removeUnsupportedAcceptEncoding(clonedRequest.Header))
+	clonedRequest.Header.Set("Accept-Encoding", removeUnsupportedAcceptEncoding(clonedRequest.Header, req.monitoring))
 
 	return clonedRequest
 }
@@ -49,12 +49,12 @@ func (req *RequestWrapper) CloneWithSupportedEncoding() *http.Request {
 func (req *RequestWrapper) GetEncodingTarget() string {
 	// Limit Accept-Encoding header to encodings we can handle.
 	// acceptEncoding := header.ParseAccept(req.Header, "Accept-Encoding")
-	acceptEncoding := parseAcceptEncoding(req.Header)
+	encodings := req.monitoring.Encodings()
+	acceptEncoding := parseAcceptEncoding(req.Header, encodings)
 	filteredEncodings := make([]encodingSpec, 0, len(acceptEncoding))
 
 	for _, a := range acceptEncoding {
-		switch a.Value {
-		case compressutil.Gzip, compressutil.Deflate:
+		if a.Value != compressutil.Identity && req.monitoring.SupportsEncoding(a.Value) {
 			filteredEncodings = append(filteredEncodings, a)
 		}
 	}
@@ -75,26 +75,37 @@ type encodingSpec struct {
 	Quality float64
 }
 
-func parseAcceptEncoding(header http.Header) []encodingSpec {
+// parseAcceptEncoding list the accepted encodings, expanding a wildcard to encodings.
+func parseAcceptEncoding(header http.Header, encodings []string) []encodingSpec {
 	encodingHeader := header.Get("Accept-Encoding")
 	if encodingHeader == "*" {
-		return []encodingSpec{{Quality: 1.0, Value: compressutil.Gzip}, {Quality: 1.0, Value: compressutil.Deflate}}
+		result := make([]encodingSpec, 0, len(encodings))
+		for _, encoding := range encodings {
+			result = append(result, encodingSpec{Quality: 1.0, Value: encoding})
+		}
+
+		return result
 	}
 
 	encodingList := strings.Split(encodingHeader, ",")
 	result := make([]encodingSpec, 0, len(encodingList))
 
 	for _, encoding := range encodingList {
-		result = append(result, parseEncodingItem(encoding))
+		result = append(result, parseEncodingItem(encoding, encodings))
 	}
 
 	return result
 }
 
-func parseEncodingItem(encoding string) encodingSpec {
+// parseEncodingItem parse a single Accept-Encoding entry. A wildcard selects the first of encodings.
+func parseEncodingItem(encoding string, encodings []string) encodingSpec {
 	encoding = strings.TrimSpace(encoding)
 	if encoding == "*" {
-		return encodingSpec{Value: compressutil.Gzip, Quality: 1.0}
+		if len(encodings) == 0 {
+			return encodingSpec{Value: compressutil.Identity, Quality: 1.0}
+		}
+
+		return encodingSpec{Value: encodings[0], Quality: 1.0}
 	}
 
 	split := strings.Split(encoding, ";q=")
@@ -112,14 +123,14 @@ func parseEncodingItem(encoding string) encodingSpec {
 	return encodingSpec{Value: split[0], Quality: quality}
 }
 
-func removeUnsupportedAcceptEncoding(header http.Header) string {
+// removeUnsupportedAcceptEncoding keep only the Accept-Encoding entries monitoring can process.
+func removeUnsupportedAcceptEncoding(header http.Header, monitoring MonitoringConfig) string {
 	encodingList := strings.Split(header.Get("Accept-Encoding"), ",")
 	result := make([]string, 0, len(encodingList))
 
 	for _, encoding := range encodingList {
 		split := strings.Split(strings.TrimSpace(encoding), ";q=")
-		switch split[0] {
-		case compressutil.Gzip, compressutil.Deflate, compressutil.Identity:
+		if split[0] != "" && monitoring.SupportsEncoding(split[0]) {
 			result = append(result, encoding)
 		}
 	}
@@ -129,6 +140,12 @@ func removeUnsupportedAcceptEncoding(header http.Header) string {
 
 // SupportsProcessing determine if http.Request is supported by this plugin.
 func (req *RequestWrapper) SupportsProcessing() bool {
+	return req.SkipReason() == ""
+}
+
+// SkipReason describe why the http.Request is not supported by this plugin.
+// An empty string is returned when the request is supported.
+func (req *RequestWrapper) SkipReason() string {
 	acceptHeader := req.Header.Get("Accept")
 	isSupported := false
 
@@ -139,7 +156,7 @@ func (req *RequestWrapper) SupportsProcessing() bool {
 	}
 
 	if !isSupported {
-		return false
+		return SkipAccept
 	}
 
 	isSupported = false
@@ -152,12 +169,16 @@ func (req *RequestWrapper) SupportsProcessing() bool {
 	}
 
 	if !isSupported {
-		return false
+		return SkipMethod
 	}
 
 	if strings.Contains(req.Header.Get("Upgrade"), "websocket") {
-		return false
+		return SkipWebSocket
+	}
+
+	if req.monitoring.Excludes(req.URL.Path) {
+		return SkipExtension
 	}
 
-	return true
+	return ""
 }
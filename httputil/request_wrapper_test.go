@@ -13,6 +13,7 @@ func TestGetEncodingTarget(t *testing.T) {
 	tests := []struct {
 		desc           string
 		acceptEncoding string
+		disabled       []string
 		expectedTarget string
 	}{
 		{
@@ -50,6 +51,24 @@ func TestGetEncodingTarget(t *testing.T) {
 			acceptEncoding: "gzip;q=0.8, deflate;q=0.9",
 			expectedTarget: "deflate",
 		},
+		{
+			desc:           "Skips disabled encoding",
+			acceptEncoding: "gzip, deflate;q=0.9",
+			disabled:       []string{"gzip"},
+			expectedTarget: "deflate",
+		},
+		{
+			desc:           "Wildcard to first enabled encoding",
+			acceptEncoding: "*",
+			disabled:       []string{"gzip"},
+			expectedTarget: "deflate",
+		},
+		{
+			desc:           "Identity when every accepted encoding is disabled",
+			acceptEncoding: "gzip",
+			disabled:       []string{"gzip"},
+			expectedTarget: "identity",
+		},
 	}
 
 	defaultMonitoring := MonitoringConfig{
@@ -71,7 +90,10 @@ func TestGetEncodingTarget(t *testing.T) {
 			}
 			request.Header.Set("Accept-Encoding", test.acceptEncoding)
 
-			wrappedRequest := WrapRequest(request, defaultMonitoring, *defaultLogWriter)
+			monitoring := defaultMonitoring
+			monitoring.DisabledEncodings = test.disabled
+
+			wrappedRequest := WrapRequest(request, monitoring, *defaultLogWriter)
 			target := wrappedRequest.GetEncodingTarget()
 			if target != test.expectedTarget {
 				t.Errorf("Expected: '%s' | Got: '%s'", test.expectedTarget, target)
@@ -84,6 +106,7 @@ func TestRemoveUnuspportedEncoding(t *testing.T) {
 	tests := []struct {
 		desc           string
 		acceptEncoding string
+		disabled       []string
 		expectedTarget string
 	}{
 		{
@@ -116,6 +139,17 @@ func TestRemoveUnuspportedEncoding(t *testing.T) {
 			acceptEncoding: "gzip;q=0.8, deflate;q=0.6",
 			expectedTarget: "gzip;q=0.8, deflate;q=0.6",
 		},
+		{
+			desc:           "Drops internal raw deflate name",
+			acceptEncoding: "deflate-raw, gzip",
+			expectedTarget: " gzip",
+		},
+		{
+			desc:           "Drops disabled encoding",
+			acceptEncoding: "gzip, deflate",
+			disabled:       []string{"deflate"},
+			expectedTarget: "gzip",
+		},
 	}
 
 	defaultMonitoring := MonitoringConfig{
@@ -137,7 +171,10 @@ func TestRemoveUnuspportedEncoding(t *testing.T) {
 			}
 			request.Header.Set("Accept-Encoding", test.acceptEncoding)
 
-			wrappedRequest := WrapRequest(request, defaultMonitoring, *defaultLogWriter)
+			monitoring := defaultMonitoring
+			monitoring.DisabledEncodings = test.disabled
+
+			wrappedRequest := WrapRequest(request, monitoring, *defaultLogWriter)
 			target := wrappedRequest.CloneWithSupportedEncoding().Header.Get("Accept-Encoding")
 
 			if target != test.expectedTarget {
@@ -152,6 +189,7 @@ func TestSupportsProcessing(t *testing.T) {
 		desc             string
 		inputType        string
 		inputMethod      string
+		inputPath        string
 		monitoringConfig MonitoringConfig
 		expectedSupport  bool
 	}{
@@ -215,6 +253,30 @@ func TestSupportsProcessing(t *testing.T) {
 				Methods: []string{"GET"},
 			},
 		},
+		{
+			desc:            "Does not support excluded extension",
+			inputType:       "text/html",
+			inputMethod:     http.MethodGet,
+			inputPath:       "/static/index.HTML.gz",
+			expectedSupport: false,
+			monitoringConfig: MonitoringConfig{
+				Types:              []string{"text/html"},
+				Methods:            []string{"GET"},
+				ExcludedExtensions: []string{"gz"},
+			},
+		},
+		{
+			desc:            "Supports path without excluded extension",
+			inputType:       "text/html",
+			inputMethod:     http.MethodGet,
+			inputPath:       "/static/gz/index.html",
+			expectedSupport: true,
+			monitoringConfig: MonitoringConfig{
+				Types:              []string{"text/html"},
+				Methods:            []string{"GET"},
+				ExcludedExtensions: []string{".gz"},
+			},
+		},
 	}
 
 	defaultLogWriter := logger.CreateLogger(logger.Error)
@@ -224,7 +286,7 @@ func TestSupportsProcessing(t *testing.T) {
 			request, err := http.NewRequestWithContext(
 				context.Background(),
 				test.inputMethod,
-				"http://google.com",
+				"http://google.com"+test.inputPath,
 				&bytes.Reader{})
 			if err != nil {
 				t.Errorf("Error creating request: %v", err)
@@ -4,6 +4,7 @@ package httputil
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -14,10 +15,19 @@ import (
 )
 
 // ResponseWrapper a wrapper used to simplify ResponseWriter data access and manipulation.
+// The status code and headers are held back until the final body is written so they
+// can still be adjusted after the wrapped handler returns.
 type ResponseWrapper struct {
 	buffer       bytes.Buffer
 	lastModified bool `default:"true"`
 	wroteHeader  bool
+	headerSent   bool
+	bytesWritten int
+	decodeLimits compressutil.Limits
+	levels       map[string]int
+	parallel     compressutil.Parallel
+	thresholds   compressutil.Thresholds
+	decoded      []string
 
 	code int `default:"200"`
 
@@ -38,6 +48,7 @@ func WrapWriter(
 		buffer:         bytes.Buffer{},
 		lastModified:   lastModified,
 		wroteHeader:    false,
+		headerSent:     false,
 		code:           http.StatusOK,
 		logWriter:      logWriter,
 		monitoring:     monitoringConfig,
@@ -45,7 +56,7 @@ func WrapWriter(
 	}
 }
 
-// WriteHeader into wrapped ResponseWriter.
+// WriteHeader record the status code to send along with the final body.
 func (wrapper *ResponseWrapper) WriteHeader(statusCode int) {
 	if wrapper.wroteHeader {
 		return
@@ -60,8 +71,20 @@ func (wrapper *ResponseWrapper) WriteHeader(statusCode int) {
 
 	// Delegates the Content-Length Header creation to the final body write.
 	wrapper.ResponseWriter.Header().Del("Content-Length")
+}
+
+// sendHeader write the recorded status code and headers to the wrapped ResponseWriter once.
+func (wrapper *ResponseWrapper) sendHeader() {
+	if !wrapper.wroteHeader {
+		wrapper.WriteHeader(http.StatusOK)
+	}
 
-	wrapper.ResponseWriter.WriteHeader(statusCode)
+	if wrapper.headerSent {
+		return
+	}
+
+	wrapper.headerSent = true
+	wrapper.ResponseWriter.WriteHeader(wrapper.code)
 }
 
 // Write data to internal buffer and mark the status code as http.StatusOK.
@@ -79,35 +102,182 @@ func (wrapper *ResponseWrapper) GetBuffer() *bytes.Buffer {
 }
 
 // GetContent load the content currently in the internal buffer
-// accounting for possible encoding.
+// accounting for possible encoding. The internal buffer is left unchanged.
+// Stacked codings such as "deflate, gzip" are decoded in reverse order.
 func (wrapper *ResponseWrapper) GetContent() ([]byte, error) {
 	encoding := wrapper.getContentEncoding()
+	encodings := compressutil.ParseEncodings(encoding)
+
+	data, decoded, err := compressutil.DecodeStack(bytes.NewBuffer(wrapper.buffer.Bytes()), encodings, wrapper.decodeLimits)
+	wrapper.decoded = decoded
 
-	return compressutil.Decode(wrapper.GetBuffer(), encoding)
+	compressLogger := wrapper.logWriter.WithSubsystem(logger.SubsystemCompress)
+	compressLogger.Debugw("Decoded content", "encoding", encoding, "codings", decoded, "size", wrapper.buffer.Len(), "decodedSize", len(data), "error", err)
+
+	return data, err
 }
 
+// ErrHeaderSent the header, including the upstream Content-Encoding, was already sent, for example after a Flush.
+var ErrHeaderSent = errors.New("response header already sent")
+
+// EncodeError the rewritten content could not be encoded with the upstream Content-Encoding.
+type EncodeError struct {
+	Encoding string
+	Err      error
+}
+
+func (err *EncodeError) Error() string {
+	return fmt.Sprintf("unable to encode content as %q: %v", err.Encoding, err.Err)
+}
+
+// Unwrap the error returned by the encoder.
+func (err *EncodeError) Unwrap() error {
+	return err.Err
+}
+
+// encodeContent encodes rewritten content, replaced in tests to simulate failures.
+var encodeContent = compressutil.EncodeParallel
+
 // SetContent write data to the internal ResponseWriter buffer
-// and match initial encoding.
-func (wrapper *ResponseWrapper) SetContent(data []byte, encoding string) {
-	bodyBytes, _ := compressutil.Encode(data, encoding)
+// and match initial encoding. Stacked codings such as "deflate, gzip" are applied in order,
+// and deflate is written raw when the upstream content read by GetContent was.
+// When encoding fails nothing is written and an *EncodeError is returned so the caller
+// can fall back to SetIdentityContent or WriteBuffer.
+func (wrapper *ResponseWrapper) SetContent(data []byte, encoding string) error {
+	compressLogger := wrapper.logWriter.WithSubsystem(logger.SubsystemCompress)
+	codings := wrapper.codings(encoding)
+	bodyBytes := data
+
+	// Once the header was flushed the upstream Content-Encoding is on the wire and must be kept.
+	if len(codings) > 0 && !wrapper.headerSent && !wrapper.thresholds.Worth(data) {
+		compressLogger.Debugw("Content not worth compressing", "encoding", encoding, "size", len(data))
+
+		return wrapper.SetIdentityContent(data)
+	}
 
-	if !wrapper.wroteHeader {
-		wrapper.WriteHeader(http.StatusOK)
+	for _, coding := range codings {
+		level, ok := wrapper.levels[coding]
+		if coding == compressutil.DeflateRaw {
+			level, ok = wrapper.levels[compressutil.Deflate]
+		}
+
+		if !ok {
+			level = compressutil.DefaultLevel
+		}
+
+		encoded, err := encodeContent(bodyBytes, coding, level, wrapper.parallel)
+		if err != nil {
+			compressLogger.Debugw("Unable to encode content", "encoding", encoding, "coding", coding, "error", err)
+
+			return &EncodeError{Encoding: coding, Err: err}
+		}
+
+		bodyBytes = encoded
+	}
+
+	compressLogger.Debugw("Encoded content", "encoding", encoding, "size", len(data), "encodedSize", len(bodyBytes))
+
+	if !wrapper.headerSent && !wrapper.thresholds.Paid(len(data), len(bodyBytes)) {
+		compressLogger.Debugw("Compression did not pay off", "encoding", encoding, "size", len(data), "encodedSize", len(bodyBytes))
+
+		return wrapper.SetIdentityContent(data)
+	}
+
+	wrapper.writeBody(bodyBytes)
+
+	return nil
+}
+
+// codings to apply for encoding, in the form found by GetContent when encoding is the upstream Content-Encoding.
+func (wrapper *ResponseWrapper) codings(encoding string) []string {
+	codings := compressutil.ParseEncodings(encoding)
+	if len(wrapper.decoded) != len(codings) {
+		return codings
+	}
+
+	for i, coding := range codings {
+		if found := wrapper.decoded[i]; found != coding && !(found == compressutil.DeflateRaw && coding == compressutil.Deflate) {
+			return codings
+		}
+	}
+
+	return wrapper.decoded
+}
+
+// SetIdentityContent write data without Content-Encoding in place of compressed content.
+// The response still depends on Accept-Encoding for caches, as other content may be compressed.
+// ErrHeaderSent is returned without writing anything once the header was sent.
+func (wrapper *ResponseWrapper) SetIdentityContent(data []byte) error {
+	if wrapper.headerSent {
+		return ErrHeaderSent
 	}
 
-	if _, err := wrapper.ResponseWriter.Write(bodyBytes); err != nil {
+	addVary(wrapper.Header(), "Accept-Encoding")
+	wrapper.Header().Del("Content-Encoding")
+	wrapper.writeBody(data)
+
+	return nil
+}
+
+// addVary add value to the Vary header unless it is already listed.
+func addVary(header http.Header, value string) {
+	for _, line := range header.Values("Vary") {
+		for _, listed := range strings.Split(line, ",") {
+			listed = strings.TrimSpace(listed)
+			if listed == "*" || strings.EqualFold(listed, value) {
+				return
+			}
+		}
+	}
+
+	header.Add("Vary", value)
+}
+
+func (wrapper *ResponseWrapper) writeBody(bodyBytes []byte) {
+	wrapper.sendHeader()
+
+	written, err := wrapper.ResponseWriter.Write(bodyBytes)
+	wrapper.bytesWritten += written
+
+	if err != nil {
 		wrapper.logWriter.LogErrorf("unable to write rewriten body: %v", err)
 		wrapper.LogHeaders()
 	}
 }
 
+// WriteBuffer write the buffered content to the wrapped ResponseWriter unchanged.
+func (wrapper *ResponseWrapper) WriteBuffer() error {
+	wrapper.sendHeader()
+
+	written, err := wrapper.ResponseWriter.Write(wrapper.buffer.Bytes())
+	wrapper.bytesWritten += written
+
+	return err
+}
+
+// StatusCode get the status code recorded for the response.
+func (wrapper *ResponseWrapper) StatusCode() int {
+	return wrapper.code
+}
+
+// BytesWritten get the number of body bytes written to the wrapped ResponseWriter.
+func (wrapper *ResponseWrapper) BytesWritten() int {
+	return wrapper.bytesWritten
+}
+
+// SetDiagnostic set a header describing how the response was processed.
+// This has no effect once the header has been sent, for example after a Flush.
+func (wrapper *ResponseWrapper) SetDiagnostic(headerName string, value string) {
+	wrapper.ResponseWriter.Header().Set(headerName, value)
+}
+
 func (wrapper *ResponseWrapper) getHeader(headerName string) string {
 	return wrapper.ResponseWriter.Header().Get(headerName)
 }
 
 // LogHeaders writes current response headers.
 func (wrapper *ResponseWrapper) LogHeaders() {
-	wrapper.logWriter.LogDebugf("Error Headers: %v", wrapper.ResponseWriter.Header())
+	wrapper.logWriter.LogDebugf("Error Headers: %v", wrapper.logWriter.FormatHeaders(wrapper.ResponseWriter.Header()))
 }
 
 // getContentEncoding get the Content-Encoding header value.
@@ -122,6 +292,12 @@ func (wrapper *ResponseWrapper) getContentType() string {
 
 // SupportsProcessing determine if HttpWrapper is supported by this plugin based on encoding.
 func (wrapper *ResponseWrapper) SupportsProcessing() bool {
+	return wrapper.SkipReason() == ""
+}
+
+// SkipReason describe why the response is not supported by this plugin.
+// An empty string is returned when the response is supported.
+func (wrapper *ResponseWrapper) SkipReason() string {
 	foundContentType := false
 
 	// If content type does not match return values with false
@@ -135,18 +311,52 @@ func (wrapper *ResponseWrapper) SupportsProcessing() bool {
 	}
 
 	if !foundContentType {
-		return false
+		return SkipContentType
 	}
 
-	encoding := wrapper.getContentEncoding()
+	// A compressed file must reach the client as is, even when served with a Content-Encoding.
+	if isCompressedPayload(wrapper.Header()) {
+		return SkipPayload
+	}
+
+	if wrapper.monitoring.Excludes(dispositionFilename(wrapper.Header())) {
+		return SkipExtension
+	}
 
 	// If content type is supported validate encoding as well
-	switch encoding {
-	case compressutil.Gzip, compressutil.Deflate, compressutil.Identity, "":
-		return true
-	default:
-		return false
+	for _, coding := range compressutil.ParseEncodings(wrapper.getContentEncoding()) {
+		if !wrapper.monitoring.SupportsEncoding(coding) {
+			return SkipEncoding
+		}
 	}
+
+	return ""
+}
+
+// SetCookie add a Set-Cookie header to the wrapped ResponseWriter.
+// This must be called before the wrapped handler writes the response header.
+func (wrapper *ResponseWrapper) SetCookie(cookie *http.Cookie) {
+	http.SetCookie(wrapper.ResponseWriter, cookie)
+}
+
+// SetDecodeLimits bound the size of content decoded by GetContent.
+func (wrapper *ResponseWrapper) SetDecodeLimits(limits compressutil.Limits) {
+	wrapper.decodeLimits = limits
+}
+
+// SetParallelCompression configure compressing large bodies in parallel blocks.
+func (wrapper *ResponseWrapper) SetParallelCompression(parallel compressutil.Parallel) {
+	wrapper.parallel = parallel
+}
+
+// SetCompressionThresholds configure when compressed content is sent as identity instead.
+func (wrapper *ResponseWrapper) SetCompressionThresholds(thresholds compressutil.Thresholds) {
+	wrapper.thresholds = thresholds
+}
+
+// SetCompressionLevels select the level used by SetContent for each encoding.
+func (wrapper *ResponseWrapper) SetCompressionLevels(levels map[string]int) {
+	wrapper.levels = levels
 }
 
 // SetLastModified update the local lastModified variable from non-package-based users.
@@ -178,6 +388,7 @@ func (wrapper *ResponseWrapper) Flush() {
 	// If WriteHeader was already called from the caller, this is a NOOP.
 	// Otherwise, codeCatcher.code is actually a 200 here.
 	wrapper.WriteHeader(wrapper.code)
+	wrapper.sendHeader()
 
 	if flusher, ok := wrapper.ResponseWriter.(http.Flusher); ok {
 		flusher.Flush()
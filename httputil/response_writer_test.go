@@ -0,0 +1,279 @@
+package httputil
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/packruler/rewrite-body/compressutil"
+	"github.com/packruler/rewrite-body/logger"
+)
+
+func TestSetContent(t *testing.T) {
+	errEncode := errors.New("encoder failed")
+
+	tests := []struct {
+		desc        string
+		encodeErr   error
+		identity    bool
+		expErr      bool
+		expBody     string
+		expEncoding string
+	}{
+		{
+			desc:        "should write the encoded content",
+			expBody:     "rewritten",
+			expEncoding: "test",
+		},
+		{
+			desc:        "should write nothing when encoding fails",
+			encodeErr:   errEncode,
+			expErr:      true,
+			expEncoding: "",
+		},
+		{
+			desc:        "should fall back to identity without Content-Encoding",
+			encodeErr:   errEncode,
+			identity:    true,
+			expErr:      true,
+			expBody:     "rewritten",
+			expEncoding: "",
+		},
+	}
+
+	defer func() { encodeContent = compressutil.EncodeParallel }()
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			encodeContent = func(data []byte, encoding string, level int, _ compressutil.Parallel) ([]byte, error) {
+				return data, test.encodeErr
+			}
+
+			recorder := httptest.NewRecorder()
+			wrapper := WrapWriter(recorder, MonitoringConfig{}, *logger.CreateLogger(logger.Error), false)
+			wrapper.Header().Set("Content-Encoding", "test")
+			wrapper.WriteHeader(http.StatusOK)
+
+			err := wrapper.SetContent([]byte("rewritten"), "test")
+
+			var encodeErr *EncodeError
+			if test.expErr != errors.As(err, &encodeErr) {
+				t.Fatalf("got error %v", err)
+			}
+
+			if test.expErr && !errors.Is(err, errEncode) {
+				t.Errorf("got error %v, want it to wrap %v", err, errEncode)
+			}
+
+			if test.identity {
+				if err := wrapper.SetIdentityContent([]byte("rewritten")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if recorder.Body.String() != test.expBody {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.expBody)
+			}
+
+			if test.expBody != "" && recorder.Result().Header.Get("Content-Encoding") != test.expEncoding {
+				t.Errorf("got Content-Encoding %q, want %q", recorder.Result().Header.Get("Content-Encoding"), test.expEncoding)
+			}
+
+			if vary := recorder.Result().Header.Get("Vary"); test.identity && vary != "Accept-Encoding" {
+				t.Errorf("got Vary %q, want %q", vary, "Accept-Encoding")
+			}
+		})
+	}
+}
+
+func TestResponseSkipReason(t *testing.T) {
+	gzipped, err := compressutil.Encode([]byte("foo is the new bar"), compressutil.Gzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc        string
+		header      map[string]string
+		excluded    []string
+		expReason   string
+		expDecoding bool
+	}{
+		{
+			desc:        "should process gzip content coding",
+			header:      map[string]string{"Content-Type": "text/html", "Content-Encoding": "gzip"},
+			expDecoding: true,
+		},
+		{
+			desc:      "should not process a gzip file",
+			header:    map[string]string{"Content-Type": "application/gzip", "Content-Encoding": "gzip"},
+			expReason: SkipPayload,
+		},
+		{
+			desc: "should not process an attachment with a compressed filename",
+			header: map[string]string{
+				"Content-Type":        "text/html",
+				"Content-Encoding":    "gzip",
+				"Content-Disposition": `attachment; filename="index.html.gz"`,
+			},
+			expReason: SkipPayload,
+		},
+		{
+			desc: "should process an attachment with another filename",
+			header: map[string]string{
+				"Content-Type":        "text/html",
+				"Content-Encoding":    "gzip",
+				"Content-Disposition": `attachment; filename="index.html"`,
+			},
+			expDecoding: true,
+		},
+		{
+			desc:      "should not process the internal raw deflate name",
+			header:    map[string]string{"Content-Type": "text/html", "Content-Encoding": "deflate-raw"},
+			expReason: SkipEncoding,
+		},
+		{
+			desc: "should not process an attachment with an excluded extension",
+			header: map[string]string{
+				"Content-Type":        "text/html",
+				"Content-Disposition": `attachment; filename="report.HTML"`,
+			},
+			excluded:  []string{".html"},
+			expReason: SkipExtension,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			monitoring := MonitoringConfig{
+				Types:              []string{"text/html", "application/gzip"},
+				ExcludedExtensions: test.excluded,
+			}
+
+			wrapper := WrapWriter(httptest.NewRecorder(), monitoring, *logger.CreateLogger(logger.Error), false)
+			for name, value := range test.header {
+				wrapper.Header().Set(name, value)
+			}
+
+			if reason := wrapper.SkipReason(); reason != test.expReason {
+				t.Fatalf("got skip reason %q, want %q", reason, test.expReason)
+			}
+
+			if !test.expDecoding {
+				return
+			}
+
+			_, _ = wrapper.Write(gzipped)
+
+			content, err := wrapper.GetContent()
+			if err != nil || string(content) != "foo is the new bar" {
+				t.Errorf("got content %q, %v", content, err)
+			}
+		})
+	}
+}
+
+func TestCompressionThresholds(t *testing.T) {
+	text := strings.Repeat("foo is the new bar ", 100)
+
+	tests := []struct {
+		desc        string
+		thresholds  compressutil.Thresholds
+		body        string
+		vary        string
+		flush       bool
+		expEncoding string
+		expVary     []string
+	}{
+		{
+			desc:        "should compress without thresholds",
+			body:        "foo",
+			expEncoding: compressutil.Gzip,
+		},
+		{
+			desc:       "should send tiny bodies as identity",
+			thresholds: compressutil.Thresholds{MinSize: 64},
+			body:       "foo",
+			expVary:    []string{"Accept-Encoding"},
+		},
+		{
+			desc:        "should compress bodies above the minimum size",
+			thresholds:  compressutil.Thresholds{MinSize: 64},
+			body:        text,
+			expEncoding: compressutil.Gzip,
+		},
+		{
+			desc:       "should send identity when compression does not pay off",
+			thresholds: compressutil.Thresholds{MinSavings: 0.1},
+			body:       "foo is the new bar",
+			vary:       "Origin",
+			expVary:    []string{"Origin", "Accept-Encoding"},
+		},
+		{
+			desc:        "should keep compressing tiny bodies once the header was flushed",
+			thresholds:  compressutil.Thresholds{MinSize: 1000},
+			body:        "foo",
+			flush:       true,
+			expEncoding: compressutil.Gzip,
+		},
+		{
+			desc:        "should keep compressing when it does not pay off once the header was flushed",
+			thresholds:  compressutil.Thresholds{MinSavings: 0.1},
+			body:        "foo is the new bar",
+			flush:       true,
+			expEncoding: compressutil.Gzip,
+		},
+		{
+			desc:       "should not repeat Vary",
+			thresholds: compressutil.Thresholds{MinSize: 64},
+			body:       "foo",
+			vary:       "origin, accept-encoding",
+			expVary:    []string{"origin, accept-encoding"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			wrapper := WrapWriter(recorder, MonitoringConfig{}, *logger.CreateLogger(logger.Error), false)
+			wrapper.SetCompressionThresholds(test.thresholds)
+			wrapper.Header().Set("Content-Encoding", compressutil.Gzip)
+
+			if test.vary != "" {
+				wrapper.Header().Set("Vary", test.vary)
+			}
+
+			wrapper.WriteHeader(http.StatusOK)
+
+			if test.flush {
+				wrapper.Flush()
+			}
+
+			if err := wrapper.SetContent([]byte(test.body), compressutil.Gzip); err != nil {
+				t.Fatal(err)
+			}
+
+			result := recorder.Result()
+			if encoding := result.Header.Get("Content-Encoding"); encoding != test.expEncoding {
+				t.Errorf("got Content-Encoding %q, want %q", encoding, test.expEncoding)
+			}
+
+			if test.expEncoding == "" && recorder.Body.String() != test.body {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.body)
+			}
+
+			if test.expEncoding != "" {
+				decoded, err := compressutil.Decode(recorder.Body, test.expEncoding)
+				if err != nil || string(decoded) != test.body {
+					t.Errorf("got decoded body %q, %v, want %q", decoded, err, test.body)
+				}
+			}
+
+			if vary := result.Header.Values("Vary"); test.expVary != nil && strings.Join(vary, "|") != strings.Join(test.expVary, "|") {
+				t.Errorf("got Vary %q, want %q", vary, test.expVary)
+			}
+		})
+	}
+}
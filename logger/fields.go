@@ -0,0 +1,99 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formatJSON build a single line JSON object for a log entry.
+// Keys are written in order: level, time, name, subsystem, msg, then bound and provided fields.
+func (logger *LogWriter) formatJSON(level LogLevel, message string, keysAndValues []interface{}) string {
+	var builder strings.Builder
+
+	builder.WriteString(`{"level":`)
+	writeJSONValue(&builder, strings.ToLower(levelNames[level]))
+	builder.WriteString(`,"time":`)
+	writeJSONValue(&builder, time.Now().UTC().Format(time.RFC3339Nano))
+
+	if logger.name != "" {
+		builder.WriteString(`,"name":`)
+		writeJSONValue(&builder, logger.name)
+	}
+
+	if logger.subsystem != "" {
+		builder.WriteString(`,"subsystem":`)
+		writeJSONValue(&builder, logger.subsystem)
+	}
+
+	builder.WriteString(`,"msg":`)
+	writeJSONValue(&builder, message)
+
+	writeJSONFields(&builder, logger.fields)
+	writeJSONFields(&builder, keysAndValues)
+
+	builder.WriteString("}")
+
+	return builder.String()
+}
+
+func writeJSONFields(builder *strings.Builder, keysAndValues []interface{}) {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		builder.WriteString(",")
+		writeJSONValue(builder, fieldKey(keysAndValues, i))
+		builder.WriteString(":")
+		writeJSONValue(builder, fieldValue(keysAndValues, i))
+	}
+}
+
+func writeJSONValue(builder *strings.Builder, value interface{}) {
+	switch typed := value.(type) {
+	case error:
+		value = typed.Error()
+	case time.Duration:
+		value = typed.String()
+	case fmt.Stringer:
+		value = typed.String()
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+
+	builder.Write(data)
+}
+
+func writeTextFields(builder *strings.Builder, keysAndValues []interface{}) {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		builder.WriteString(" ")
+		builder.WriteString(fieldKey(keysAndValues, i))
+		builder.WriteString("=")
+
+		text := fmt.Sprint(fieldValue(keysAndValues, i))
+		if strings.ContainsAny(text, " \t\n\"=") {
+			text = strconv.Quote(text)
+		}
+
+		builder.WriteString(text)
+	}
+}
+
+func fieldKey(keysAndValues []interface{}, index int) string {
+	if key, ok := keysAndValues[index].(string); ok {
+		return key
+	}
+
+	return fmt.Sprint(keysAndValues[index])
+}
+
+// fieldValue get the value paired with the key at index, tolerating a missing trailing value.
+func fieldValue(keysAndValues []interface{}, index int) interface{} {
+	if index+1 < len(keysAndValues) {
+		return keysAndValues[index+1]
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SubsystemHandler logs written while applying rewrites to a response.
+	SubsystemHandler string = "handler"
+	// SubsystemHTTP logs written while wrapping requests and responses.
+	SubsystemHTTP string = "http"
+	// SubsystemCompress logs written while decoding and encoding response content.
+	SubsystemCompress string = "compress"
+)
+
+// ParseLevel convert a level name such as "debug" or "warn", or a number between -2 and 2, to a LogLevel.
+func ParseLevel(value string) (LogLevel, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	switch value {
+	case "trace":
+		return Trace, nil
+	case "debug":
+		return Debug, nil
+	case "info", "":
+		return Info, nil
+	case "warn", "warning":
+		return Warning, nil
+	case "error":
+		return Error, nil
+	}
+
+	number, err := strconv.ParseInt(value, 10, 8)
+	if err != nil || LogLevel(number) < Trace || LogLevel(number) > Error {
+		return Info, fmt.Errorf("unknown log level %q", value)
+	}
+
+	return LogLevel(number), nil
+}
+
+// String get the lower case name of the LogLevel.
+func (level LogLevel) String() string {
+	if name, ok := levelNames[level]; ok {
+		return strings.ToLower(name)
+	}
+
+	return strconv.Itoa(int(level))
+}
+
+// MarshalText write the LogLevel as its name.
+func (level LogLevel) MarshalText() ([]byte, error) {
+	return []byte(level.String()), nil
+}
+
+// UnmarshalText read a LogLevel from a name or number.
+func (level *LogLevel) UnmarshalText(text []byte) error {
+	parsed, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+
+	*level = parsed
+
+	return nil
+}
+
+// UnmarshalJSON read a LogLevel from a JSON string or number.
+func (level *LogLevel) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		text = string(data)
+	}
+
+	return level.UnmarshalText([]byte(text))
+}
@@ -4,8 +4,10 @@ package logger
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"log"
-	"os"
+	"net/http"
+	"strings"
 )
 
 // LogLevel type definition of supported log levels.
@@ -24,50 +26,192 @@ const (
 	Error
 )
 
+const (
+	// FormatText writes logs as prefixed lines of text.
+	FormatText string = "text"
+	// FormatJSON writes logs as one JSON object per line.
+	FormatJSON string = "json"
+)
+
+var levelNames = map[LogLevel]string{
+	Trace:   "TRACE",
+	Debug:   "DEBUG",
+	Info:    "INFO",
+	Warning: "WARNING",
+	Error:   "ERROR",
+}
+
+// Config holds the options used to create a LogWriter.
+type Config struct {
+	Level  LogLevel
+	Format string
+	// Name of the middleware instance included with every log entry.
+	Name string
+	// Overrides levels for LogWriters created by WithSubsystem, keyed by subsystem name.
+	Overrides map[string]LogLevel
+	// Redactor sanitizes content passed to FormatBody and FormatHeaders. Defaults are used when nil.
+	Redactor *Redactor
+	// Sinks the destinations of logs. Standard output and standard error are used when empty.
+	Sinks []SinkConfig
+}
+
 // LogWriter the struct used for writing logs.
 type LogWriter struct {
-	level   LogLevel
-	loggers map[LogLevel]*log.Logger
+	level     LogLevel
+	baseLevel LogLevel
+	overrides map[string]LogLevel
+	subsystem string
+	format    string
+	name      string
+	fields    []interface{}
+	redactor  *Redactor
+	loggers   map[LogLevel]*log.Logger
 }
 
 // CreateLogger create the LogWriter struct with required content.
 func CreateLogger(level LogLevel) *LogWriter {
-	loggers := make(map[LogLevel]*log.Logger, Error-1)
+	writers, _ := createWriters(nil)
 
-	loggers[Trace] = log.New(os.Stdout, "Rewrite-Body | TRACE", log.Ldate|log.Ltime|log.Lshortfile)
-	loggers[Debug] = log.New(os.Stdout, "Rewrite-Body | DEBUG", log.Ldate|log.Ltime|log.Lshortfile)
-	loggers[Info] = log.New(os.Stdout, "Rewrite-Body | INFO", log.Ldate|log.Ltime|log.Lshortfile)
-	loggers[Warning] = log.New(os.Stdout, "Rewrite-Body | WARNING", log.Ldate|log.Ltime|log.Lshortfile)
-	loggers[Error] = log.New(os.Stderr, "Rewrite-Body | ERROR", log.Ldate|log.Ltime|log.Lshortfile)
+	return createLoggerWithWriters(Config{Level: level}, writers)
+}
 
-	return &LogWriter{
-		level:   level,
-		loggers: loggers,
+// CreateLoggerFromConfig create the LogWriter struct for the provided Config.
+func CreateLoggerFromConfig(config Config) (*LogWriter, error) {
+	writers, err := createWriters(config.Sinks)
+	if err != nil {
+		return nil, err
 	}
+
+	return createLoggerWithWriters(config, writers), nil
 }
 
 func createLoggerWithBuffer(level LogLevel, buffer *bytes.Buffer) *LogWriter {
-	loggers := make(map[LogLevel]*log.Logger, Error-1)
+	return createLoggerWithConfigAndBuffer(Config{Level: level}, buffer)
+}
+
+func createLoggerWithConfigAndBuffer(config Config, buffer *bytes.Buffer) *LogWriter {
+	writers := make(map[LogLevel]io.Writer, len(levelNames))
+	for level := range levelNames {
+		writers[level] = buffer
+	}
 
-	loggers[Trace] = log.New(buffer, "Rewrite-Body | TRACE", log.Ldate|log.Ltime|log.Lshortfile)
-	loggers[Debug] = log.New(buffer, "Rewrite-Body | DEBUG", log.Ldate|log.Ltime|log.Lshortfile)
-	loggers[Info] = log.New(buffer, "Rewrite-Body | INFO", log.Ldate|log.Ltime|log.Lshortfile)
-	loggers[Warning] = log.New(buffer, "Rewrite-Body | WARNING", log.Ldate|log.Ltime|log.Lshortfile)
-	loggers[Error] = log.New(buffer, "Rewrite-Body | ERROR", log.Ldate|log.Ltime|log.Lshortfile)
+	return createLoggerWithWriters(config, writers)
+}
+
+func createLoggerWithWriters(config Config, writers map[LogLevel]io.Writer) *LogWriter {
+	loggers := make(map[LogLevel]*log.Logger, len(writers))
+
+	for level, writer := range writers {
+		if config.Format == FormatJSON {
+			loggers[level] = log.New(writer, "", 0)
+		} else {
+			loggers[level] = log.New(writer, "Rewrite-Body | "+levelNames[level]+" ", log.Ldate|log.Ltime|log.Lshortfile)
+		}
+	}
+
+	format := config.Format
+	if format == "" {
+		format = FormatText
+	}
+
+	redactor := config.Redactor
+	if redactor == nil {
+		redactor, _ = NewRedactor(RedactionConfig{})
+	}
 
 	return &LogWriter{
-		level:   level,
-		loggers: loggers,
+		level:     config.Level,
+		baseLevel: config.Level,
+		overrides: config.Overrides,
+		format:    format,
+		name:      config.Name,
+		redactor:  redactor,
+		loggers:   loggers,
+	}
+}
+
+// WithSubsystem create a copy of the LogWriter for a named subsystem.
+// The level configured in Overrides for the subsystem is used when present.
+func (logger *LogWriter) WithSubsystem(subsystem string) LogWriter {
+	result := *logger
+
+	result.subsystem = subsystem
+	result.level = logger.baseLevel
+
+	if level, ok := logger.overrides[subsystem]; ok {
+		result.level = level
 	}
+
+	return result
+}
+
+// With create a copy of the LogWriter that adds the provided key-value pairs to every log entry.
+func (logger *LogWriter) With(keysAndValues ...interface{}) LogWriter {
+	result := *logger
+
+	result.fields = make([]interface{}, 0, len(logger.fields)+len(keysAndValues))
+	result.fields = append(result.fields, logger.fields...)
+	result.fields = append(result.fields, keysAndValues...)
+
+	return result
+}
+
+// Enabled determine if logs at level would be written.
+func (logger *LogWriter) Enabled(level LogLevel) bool {
+	return level >= logger.level
 }
 
-func (logger *LogWriter) writeLog(level LogLevel, message string) {
+// FormatBody sanitize response content before it is written to logs.
+func (logger *LogWriter) FormatBody(data []byte) string {
+	return logger.redactor.Body(data)
+}
+
+// RedactBody sanitize response content stored outside of logs, keeping its full length.
+func (logger *LogWriter) RedactBody(data []byte) string {
+	return logger.redactor.Redact(data)
+}
+
+// FormatText apply redaction patterns and truncation to text before it is written to logs.
+func (logger *LogWriter) FormatText(data []byte) string {
+	return logger.redactor.Text(data)
+}
+
+// FormatHeaders sanitize headers before they are written to logs.
+func (logger *LogWriter) FormatHeaders(header http.Header) http.Header {
+	return logger.redactor.Headers(header)
+}
+
+func (logger *LogWriter) writeLog(level LogLevel, message string, keysAndValues ...interface{}) {
 	if level < logger.level {
 		return
 	}
 
 	output := logger.loggers[level]
-	output.Print(message)
+
+	if logger.format == FormatJSON {
+		output.Print(logger.formatJSON(level, message, keysAndValues))
+
+		return
+	}
+
+	if logger.subsystem == "" && len(logger.fields) == 0 && len(keysAndValues) == 0 {
+		output.Print(message)
+
+		return
+	}
+
+	var builder strings.Builder
+
+	builder.WriteString(message)
+
+	if logger.subsystem != "" {
+		writeTextFields(&builder, []interface{}{"subsystem", logger.subsystem})
+	}
+
+	writeTextFields(&builder, logger.fields)
+	writeTextFields(&builder, keysAndValues)
+
+	output.Print(builder.String())
 }
 
 // LogTrace write Trace level logs.
@@ -119,3 +263,28 @@ func (logger *LogWriter) LogWarningf(format string, a ...interface{}) {
 func (logger *LogWriter) LogErrorf(format string, a ...interface{}) {
 	logger.writeLog(Error, fmt.Sprintf(format, a...))
 }
+
+// Tracew write Trace level logs with additional key-value pairs.
+func (logger *LogWriter) Tracew(message string, keysAndValues ...interface{}) {
+	logger.writeLog(Trace, message, keysAndValues...)
+}
+
+// Debugw write Debug level logs with additional key-value pairs.
+func (logger *LogWriter) Debugw(message string, keysAndValues ...interface{}) {
+	logger.writeLog(Debug, message, keysAndValues...)
+}
+
+// Infow write Info level logs with additional key-value pairs.
+func (logger *LogWriter) Infow(message string, keysAndValues ...interface{}) {
+	logger.writeLog(Info, message, keysAndValues...)
+}
+
+// Warningw write Warning level logs with additional key-value pairs.
+func (logger *LogWriter) Warningw(message string, keysAndValues ...interface{}) {
+	logger.writeLog(Warning, message, keysAndValues...)
+}
+
+// Errorw write Error level logs with additional key-value pairs.
+func (logger *LogWriter) Errorw(message string, keysAndValues ...interface{}) {
+	logger.writeLog(Error, message, keysAndValues...)
+}
@@ -2,7 +2,15 @@ package logger
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLogging(t *testing.T) {
@@ -228,3 +236,249 @@ func TestLogging(t *testing.T) {
 		})
 	}
 }
+
+func TestStructuredLogging(t *testing.T) {
+	tests := []struct {
+		desc     string
+		format   string
+		expected []string
+	}{
+		{
+			desc:   "Text format should separate prefix and append fields",
+			format: FormatText,
+			expected: []string{
+				"Rewrite-Body | INFO ",
+				"test message method=GET path=/ rules=\"[0 3]\" error=\"bad thing\"",
+			},
+		},
+		{
+			desc:   "JSON format should write ordered fields",
+			format: FormatJSON,
+			expected: []string{
+				`{"level":"info","time":"`,
+				`"name":"rewriteBody","msg":"test message","method":"GET","path":"/","rules":[0,3],"error":"bad thing"}`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var buffer bytes.Buffer
+
+			logger := createLoggerWithConfigAndBuffer(Config{Level: Info, Format: test.format, Name: "rewriteBody"}, &buffer)
+			requestLogger := logger.With("method", "GET", "path", "/")
+
+			requestLogger.Debugw("hidden")
+			requestLogger.Infow("test message", "rules", []int{0, 3}, "error", errors.New("bad thing"))
+
+			loggedContent := buffer.String()
+			for _, expected := range test.expected {
+				if !strings.Contains(loggedContent, expected) {
+					t.Errorf("Expected log to contain '%s': '%s'", expected, loggedContent)
+				}
+			}
+
+			if strings.Count(loggedContent, "\n") != 1 {
+				t.Errorf("Expected a single log line: '%s'", loggedContent)
+			}
+		})
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected LogLevel
+		expErr   bool
+	}{
+		{input: "trace", expected: Trace},
+		{input: "DEBUG", expected: Debug},
+		{input: "info", expected: Info},
+		{input: "warn", expected: Warning},
+		{input: "warning", expected: Warning},
+		{input: "error", expected: Error},
+		{input: "-1", expected: Debug},
+		{input: "2", expected: Error},
+		{input: "3", expErr: true},
+		{input: "verbose", expErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			level, err := ParseLevel(test.input)
+			if test.expErr != (err != nil) {
+				t.Fatalf("Unexpected error result for '%s': %v", test.input, err)
+			}
+
+			if !test.expErr && level != test.expected {
+				t.Errorf("Expected: '%v' | Got: '%v'", test.expected, level)
+			}
+		})
+	}
+}
+
+func TestLevelUnmarshalJSON(t *testing.T) {
+	var config struct {
+		Level  LogLevel            `json:"level"`
+		Levels map[string]LogLevel `json:"levels"`
+	}
+
+	err := json.Unmarshal([]byte(`{"level": -1, "levels": {"compress": "debug", "handler": "warn"}}`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Level != Debug || config.Levels[SubsystemCompress] != Debug || config.Levels[SubsystemHandler] != Warning {
+		t.Errorf("Unexpected levels: %v %v", config.Level, config.Levels)
+	}
+}
+
+func TestSubsystemOverrides(t *testing.T) {
+	var buffer bytes.Buffer
+
+	logger := createLoggerWithConfigAndBuffer(Config{
+		Level:     Info,
+		Overrides: map[string]LogLevel{SubsystemCompress: Debug},
+	}, &buffer)
+
+	handlerLogger := logger.WithSubsystem(SubsystemHandler)
+	handlerLogger.LogDebug("handler")
+
+	if buffer.Len() != 0 {
+		t.Errorf("Handler debug log written with Info level: '%s'", buffer.String())
+	}
+
+	compressLogger := handlerLogger.WithSubsystem(SubsystemCompress)
+	compressLogger.LogDebug("compress")
+
+	if !strings.Contains(buffer.String(), "compress subsystem=compress") {
+		t.Errorf("Compress debug log NOT written with Debug override: '%s'", buffer.String())
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	redactor, err := NewRedactor(RedactionConfig{
+		MaxLength: 24,
+		Patterns:  []string{`token=\w+`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := redactor.Body([]byte("token=abc123 and a long tail of content"))
+	if body != "[REDACTED] and a long ta... (13 bytes truncated)" {
+		t.Errorf("Unexpected body: '%s'", body)
+	}
+
+	headers := redactor.Headers(http.Header{
+		"Authorization": []string{"Bearer abc"},
+		"Set-Cookie":    []string{"session=abc"},
+		"Accept":        []string{"text/html"},
+	})
+	if headers.Get("Authorization") != "[REDACTED]" || headers.Get("Set-Cookie") != "[REDACTED]" {
+		t.Errorf("Sensitive headers not redacted: %v", headers)
+	}
+
+	if headers.Get("Accept") != "text/html" {
+		t.Errorf("Unexpected header change: %v", headers)
+	}
+
+	hashRedactor, err := NewRedactor(RedactionConfig{BodyMode: BodyHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashed := hashRedactor.Body([]byte("secret"))
+	if !strings.HasPrefix(hashed, "sha256:") || strings.Contains(hashed, "secret") {
+		t.Errorf("Unexpected hashed body: '%s'", hashed)
+	}
+
+	if _, err := NewRedactor(RedactionConfig{Patterns: []string{"*"}}); err == nil {
+		t.Error("Expected error for invalid pattern")
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rewrite-body.log")
+
+	logger, err := CreateLoggerFromConfig(Config{
+		Level: Info,
+		Sinks: []SinkConfig{
+			{Type: SinkFile, Levels: []string{"info", "warn"}, Path: path, MaxSize: 150, MaxBackups: 2},
+			{Type: SinkDiscard, Levels: []string{"error"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		logger.LogInfof("entry %d", i)
+	}
+
+	logger.LogError("dropped")
+
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Errorf("Expected rotated file: %v", err)
+	}
+
+	if _, err := os.Stat(path + ".2"); err != nil {
+		t.Errorf("Expected second rotated file: %v", err)
+	}
+
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("Expected rotated files beyond MaxBackups to be removed")
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(current), "entry 9") || strings.Contains(string(current), "dropped") {
+		t.Errorf("Unexpected current log file: '%s'", current)
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "log.sock")
+
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets not supported: %v", err)
+	}
+	defer listener.Close()
+
+	logger, err := CreateLoggerFromConfig(Config{
+		Level: Info,
+		Sinks: []SinkConfig{{Type: SinkSyslog, Path: socket, Tag: "test"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.LogWarning("syslog entry")
+
+	buffer := make([]byte, 1024)
+	_ = listener.SetReadDeadline(time.Now().Add(time.Second))
+
+	read, err := listener.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := string(buffer[:read])
+	if !strings.HasPrefix(message, "<12>") || !strings.Contains(message, "test[") || !strings.HasSuffix(message, "syslog entry") {
+		t.Errorf("Unexpected syslog message: '%s'", message)
+	}
+}
+
+func TestUnknownSink(t *testing.T) {
+	if _, err := CreateLoggerFromConfig(Config{Sinks: []SinkConfig{{Type: "kafka"}}}); err == nil {
+		t.Error("Expected error for unknown sink type")
+	}
+
+	if _, err := CreateLoggerFromConfig(Config{Sinks: []SinkConfig{{Type: SinkFile}}}); err == nil {
+		t.Error("Expected error for file sink without path")
+	}
+}
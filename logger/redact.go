@@ -0,0 +1,142 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+const (
+	// BodyFull writes content to logs after applying redaction patterns and truncation.
+	BodyFull string = "full"
+	// BodyHash writes only a hash and the length of content to logs.
+	BodyHash string = "hash"
+
+	defaultMaxLength = 4096
+	redacted         = "[REDACTED]"
+)
+
+var defaultRedactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+
+// RedactionConfig holds the options for writing response content and headers to logs.
+type RedactionConfig struct {
+	// MaxLength of content written to logs. Defaults to 4096, negative values disable truncation.
+	MaxLength int `json:"maxLength,omitempty" yaml:"maxLength,omitempty" toml:"maxLength,omitempty"`
+	// Patterns are regular expressions whose matches are replaced before content is logged.
+	Patterns []string `json:"patterns,omitempty" yaml:"patterns,omitempty" toml:"patterns,omitempty"`
+	// Headers whose values are never logged. Defaults to Authorization, Cookie and Set-Cookie.
+	Headers []string `json:"headers,omitempty" yaml:"headers,omitempty" toml:"headers,omitempty"`
+	// BodyMode is either full (default) or hash.
+	BodyMode string `json:"bodyMode,omitempty" yaml:"bodyMode,omitempty" toml:"bodyMode,omitempty"`
+}
+
+// Redactor sanitizes content before it is written to logs.
+type Redactor struct {
+	maxLength int
+	patterns  []*regexp.Regexp
+	headers   map[string]bool
+	hashOnly  bool
+}
+
+// NewRedactor compile a RedactionConfig into a Redactor.
+func NewRedactor(config RedactionConfig) (*Redactor, error) {
+	result := &Redactor{
+		maxLength: config.MaxLength,
+		patterns:  make([]*regexp.Regexp, 0, len(config.Patterns)),
+		headers:   make(map[string]bool),
+	}
+
+	if result.maxLength == 0 {
+		result.maxLength = defaultMaxLength
+	}
+
+	switch config.BodyMode {
+	case BodyFull, "":
+	case BodyHash:
+		result.hashOnly = true
+	default:
+		return nil, fmt.Errorf("unknown body mode %q", config.BodyMode)
+	}
+
+	for _, pattern := range config.Patterns {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling redaction pattern %q: %w", pattern, err)
+		}
+
+		result.patterns = append(result.patterns, regex)
+	}
+
+	headers := config.Headers
+	if len(headers) == 0 {
+		headers = defaultRedactedHeaders
+	}
+
+	for _, header := range headers {
+		result.headers[http.CanonicalHeaderKey(header)] = true
+	}
+
+	return result, nil
+}
+
+// Body sanitize content for logging by applying hashing, redaction patterns and truncation.
+func (redactor *Redactor) Body(data []byte) string {
+	if redactor.hashOnly {
+		sum := sha256.Sum256(data)
+
+		return fmt.Sprintf("sha256:%s (%d bytes)", hex.EncodeToString(sum[:]), len(data))
+	}
+
+	return redactor.Text(data)
+}
+
+// Redact sanitize content kept outside of logs, such as captures, by applying hashing and redaction
+// patterns without truncating it.
+func (redactor *Redactor) Redact(data []byte) string {
+	if redactor.hashOnly {
+		return redactor.Body(data)
+	}
+
+	for _, pattern := range redactor.patterns {
+		data = pattern.ReplaceAll(data, []byte(redacted))
+	}
+
+	return string(data)
+}
+
+// Text apply redaction patterns and truncation to content that should stay readable.
+func (redactor *Redactor) Text(data []byte) string {
+	for _, pattern := range redactor.patterns {
+		data = pattern.ReplaceAll(data, []byte(redacted))
+	}
+
+	if redactor.maxLength > 0 && len(data) > redactor.maxLength {
+		return fmt.Sprintf("%s... (%d bytes truncated)", data[:redactor.maxLength], len(data)-redactor.maxLength)
+	}
+
+	return string(data)
+}
+
+// Headers create a copy of header with redacted values for logging.
+func (redactor *Redactor) Headers(header http.Header) http.Header {
+	result := make(http.Header, len(header))
+
+	for name, values := range header {
+		if redactor.headers[http.CanonicalHeaderKey(name)] {
+			result[name] = []string{redacted}
+
+			continue
+		}
+
+		sanitized := make([]string, 0, len(values))
+		for _, value := range values {
+			sanitized = append(sanitized, redactor.Text([]byte(value)))
+		}
+
+		result[name] = sanitized
+	}
+
+	return result
+}
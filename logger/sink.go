@@ -0,0 +1,320 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// SinkStdout writes logs to standard output.
+	SinkStdout string = "stdout"
+	// SinkStderr writes logs to standard error.
+	SinkStderr string = "stderr"
+	// SinkFile writes logs to a file rotated by size.
+	SinkFile string = "file"
+	// SinkSyslog writes logs in syslog format to a local UNIX socket.
+	SinkSyslog string = "syslog"
+	// SinkDiscard drops logs.
+	SinkDiscard string = "discard"
+
+	defaultMaxSize      = 10 * 1024 * 1024
+	defaultMaxBackups   = 3
+	defaultSyslogSocket = "/dev/log"
+	defaultSyslogTag    = "rewrite-body"
+	syslogFacilityUser  = 1
+)
+
+// SinkConfig holds a destination for logs of the listed levels.
+type SinkConfig struct {
+	Type string `json:"type" yaml:"type" toml:"type"`
+	// Levels written to this sink by name or number, as parsed by ParseLevel. All levels are written when empty.
+	Levels []string `json:"levels,omitempty" yaml:"levels,omitempty" toml:"levels,omitempty"`
+	// Path of the file, or of the UNIX socket for syslog which defaults to /dev/log.
+	Path string `json:"path,omitempty" yaml:"path,omitempty" toml:"path,omitempty"`
+	// MaxSize in bytes a file reaches before it is rotated. Defaults to 10MiB.
+	MaxSize int64 `json:"maxSize,omitempty" yaml:"maxSize,omitempty" toml:"maxSize,omitempty"`
+	// MaxBackups number of rotated files kept. Defaults to 3.
+	MaxBackups int `json:"maxBackups,omitempty" yaml:"maxBackups,omitempty" toml:"maxBackups,omitempty"`
+	// Tag identifying entries written to syslog. Defaults to rewrite-body.
+	Tag string `json:"tag,omitempty" yaml:"tag,omitempty" toml:"tag,omitempty"`
+}
+
+var (
+	openFilesLock sync.Mutex
+	// openFiles shares rotating files between LogWriters so reloading configuration reuses handles.
+	openFiles = make(map[string]*rotatingFile)
+)
+
+// createWriters build the writer for every level from the configured sinks.
+// Levels not covered by any sink keep the default of standard output, or standard error for Error.
+func createWriters(sinks []SinkConfig) (map[LogLevel]io.Writer, error) {
+	targets := make(map[LogLevel][]io.Writer, len(levelNames))
+
+	for _, sink := range sinks {
+		levels, err := sinkLevels(sink)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, level := range levels {
+			writer, err := createSinkWriter(sink, level)
+			if err != nil {
+				return nil, err
+			}
+
+			targets[level] = append(targets[level], writer)
+		}
+	}
+
+	writers := make(map[LogLevel]io.Writer, len(levelNames))
+
+	for level := range levelNames {
+		switch len(targets[level]) {
+		case 0:
+			writers[level] = os.Stdout
+			if level == Error {
+				writers[level] = os.Stderr
+			}
+		case 1:
+			writers[level] = targets[level][0]
+		default:
+			writers[level] = io.MultiWriter(targets[level]...)
+		}
+	}
+
+	return writers, nil
+}
+
+// sinkLevels parse the levels written to sink, defaulting to every level.
+func sinkLevels(sink SinkConfig) ([]LogLevel, error) {
+	if len(sink.Levels) == 0 {
+		return []LogLevel{Trace, Debug, Info, Warning, Error}, nil
+	}
+
+	levels := make([]LogLevel, 0, len(sink.Levels))
+
+	for _, value := range sink.Levels {
+		level, err := ParseLevel(value)
+		if err != nil {
+			return nil, fmt.Errorf("log sink %q: %w", sink.Type, err)
+		}
+
+		levels = append(levels, level)
+	}
+
+	return levels, nil
+}
+
+func createSinkWriter(sink SinkConfig, level LogLevel) (io.Writer, error) {
+	switch sink.Type {
+	case SinkStdout, "":
+		return os.Stdout, nil
+	case SinkStderr:
+		return os.Stderr, nil
+	case SinkDiscard:
+		return io.Discard, nil
+	case SinkFile:
+		if sink.Path == "" {
+			return nil, fmt.Errorf("log sink %q requires a path", SinkFile)
+		}
+
+		return openRotatingFile(sink)
+	case SinkSyslog:
+		return newSyslogWriter(sink, level), nil
+	default:
+		return nil, fmt.Errorf("unknown log sink type %q", sink.Type)
+	}
+}
+
+// rotatingFile an io.Writer appending to a file that is renamed once it reaches maxSize.
+type rotatingFile struct {
+	lock       sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotatingFile(sink SinkConfig) (*rotatingFile, error) {
+	openFilesLock.Lock()
+	defer openFilesLock.Unlock()
+
+	maxSize := sink.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+
+	maxBackups := sink.MaxBackups
+	if maxBackups <= 0 {
+		maxBackups = defaultMaxBackups
+	}
+
+	if existing, ok := openFiles[sink.Path]; ok {
+		existing.lock.Lock()
+		existing.maxSize = maxSize
+		existing.maxBackups = maxBackups
+		existing.lock.Unlock()
+
+		return existing, nil
+	}
+
+	result := &rotatingFile{
+		path:       sink.Path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := result.open(); err != nil {
+		return nil, err
+	}
+
+	openFiles[sink.Path] = result
+
+	return result, nil
+}
+
+func (rotating *rotatingFile) open() error {
+	file, err := os.OpenFile(rotating.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("unable to open log file %q: %w", rotating.path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+
+		return fmt.Errorf("unable to stat log file %q: %w", rotating.path, err)
+	}
+
+	rotating.file = file
+	rotating.size = info.Size()
+
+	return nil
+}
+
+// Write data to the file, rotating first when data would grow the file beyond maxSize.
+func (rotating *rotatingFile) Write(data []byte) (int, error) {
+	rotating.lock.Lock()
+	defer rotating.lock.Unlock()
+
+	if rotating.size > 0 && rotating.size+int64(len(data)) > rotating.maxSize {
+		if err := rotating.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	written, err := rotating.file.Write(data)
+	rotating.size += int64(written)
+
+	return written, err
+}
+
+// rotate shift path.N to path.N+1, dropping the oldest beyond maxBackups, and reopen path.
+func (rotating *rotatingFile) rotate() error {
+	if err := rotating.file.Close(); err != nil {
+		return fmt.Errorf("unable to close log file %q: %w", rotating.path, err)
+	}
+
+	_ = os.Remove(rotating.backupPath(rotating.maxBackups))
+
+	for index := rotating.maxBackups - 1; index > 0; index-- {
+		_ = os.Rename(rotating.backupPath(index), rotating.backupPath(index+1))
+	}
+
+	if err := os.Rename(rotating.path, rotating.backupPath(1)); err != nil {
+		return fmt.Errorf("unable to rotate log file %q: %w", rotating.path, err)
+	}
+
+	return rotating.open()
+}
+
+func (rotating *rotatingFile) backupPath(index int) string {
+	return rotating.path + "." + strconv.Itoa(index)
+}
+
+// syslogWriter an io.Writer sending each entry as an RFC 3164 message to a UNIX datagram socket.
+type syslogWriter struct {
+	lock     sync.Mutex
+	socket   string
+	tag      string
+	hostname string
+	priority int
+	conn     net.Conn
+}
+
+func newSyslogWriter(sink SinkConfig, level LogLevel) *syslogWriter {
+	socket := sink.Path
+	if socket == "" {
+		socket = defaultSyslogSocket
+	}
+
+	tag := sink.Tag
+	if tag == "" {
+		tag = defaultSyslogTag
+	}
+
+	hostname, _ := os.Hostname()
+
+	return &syslogWriter{
+		socket:   socket,
+		tag:      tag,
+		hostname: hostname,
+		priority: syslogFacilityUser*8 + syslogSeverity(level),
+	}
+}
+
+func syslogSeverity(level LogLevel) int {
+	switch level {
+	case Error:
+		return 3
+	case Warning:
+		return 4
+	case Info:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// Write a single log entry, reconnecting once if the socket was closed.
+func (writer *syslogWriter) Write(data []byte) (int, error) {
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+
+	message := fmt.Sprintf(
+		"<%d>%s %s %s[%d]: %s",
+		writer.priority,
+		time.Now().Format(time.Stamp),
+		writer.hostname,
+		writer.tag,
+		os.Getpid(),
+		bytes.TrimRight(data, "\n"),
+	)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if writer.conn == nil {
+			conn, err := net.Dial("unixgram", writer.socket)
+			if err != nil {
+				return 0, fmt.Errorf("unable to connect to syslog socket %q: %w", writer.socket, err)
+			}
+
+			writer.conn = conn
+		}
+
+		if _, err := writer.conn.Write([]byte(message)); err == nil {
+			return len(data), nil
+		}
+
+		_ = writer.conn.Close()
+		writer.conn = nil
+	}
+
+	return 0, fmt.Errorf("unable to write to syslog socket %q", writer.socket)
+}
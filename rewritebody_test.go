@@ -158,7 +158,7 @@ func TestServeHTTP(t *testing.T) {
 			config := &handler.Config{
 				LastModified: test.lastModified,
 				Rewrites:     test.rewrites,
-				LogLevel:     -1,
+				LogLevel:     "debug",
 			}
 
 			next := func(responseWriter http.ResponseWriter, req *http.Request) {
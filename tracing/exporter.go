@@ -0,0 +1,301 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	// ExporterFile writes spans as OTLP JSON lines to a local file.
+	ExporterFile string = "file"
+
+	defaultServiceName = "rewrite-body"
+	defaultMaxSize     = 10 * 1024 * 1024
+	scopeName          = "github.com/packruler/rewrite-body"
+	spanKindInternal   = 1
+)
+
+// Exporter receives the spans of a finished trace.
+type Exporter interface {
+	Export(spans []*Span) error
+}
+
+// ExporterFactory create an Exporter from the tracing configuration.
+type ExporterFactory func(config Config) (Exporter, error)
+
+var (
+	exportersLock sync.RWMutex
+	exporters     = map[string]ExporterFactory{
+		ExporterFile: newFileExporter,
+	}
+)
+
+// RegisterExporter make an exporter available by name to Config.Exporter.
+func RegisterExporter(name string, factory ExporterFactory) {
+	exportersLock.Lock()
+	defer exportersLock.Unlock()
+
+	exporters[name] = factory
+}
+
+// NewExporter create the exporter named in config.
+func NewExporter(config Config) (Exporter, error) {
+	name := config.Exporter
+	if name == "" {
+		name = ExporterFile
+	}
+
+	exportersLock.RLock()
+	factory, ok := exporters[name]
+	exportersLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown tracing exporter %q", name)
+	}
+
+	return factory(config)
+}
+
+// FileExporter appends one OTLP ExportTraceServiceRequest JSON document per trace to a file.
+type FileExporter struct {
+	file        *traceFile
+	serviceName string
+}
+
+var (
+	openFilesLock sync.Mutex
+	// openFiles shares trace files between exporters so reloading configuration reuses handles.
+	openFiles = make(map[string]*traceFile)
+)
+
+// traceFile a trace file shared by every exporter writing to its path, replaced by a new file once it reaches maxSize.
+type traceFile struct {
+	lock    sync.Mutex
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+}
+
+func newFileExporter(config Config) (Exporter, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("tracing exporter %q requires a path", ExporterFile)
+	}
+
+	if config.MaxSize < 0 {
+		return nil, fmt.Errorf("tracing maxSize must not be negative")
+	}
+
+	file, err := openTraceFile(config)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+
+	return &FileExporter{file: file, serviceName: serviceName}, nil
+}
+
+func openTraceFile(config Config) (*traceFile, error) {
+	openFilesLock.Lock()
+	defer openFilesLock.Unlock()
+
+	maxSize := config.MaxSize
+	if maxSize == 0 {
+		maxSize = defaultMaxSize
+	}
+
+	if existing, ok := openFiles[config.Path]; ok {
+		existing.lock.Lock()
+		existing.maxSize = maxSize
+		existing.lock.Unlock()
+
+		return existing, nil
+	}
+
+	result := &traceFile{path: config.Path, maxSize: maxSize}
+	if err := result.open(); err != nil {
+		return nil, err
+	}
+
+	openFiles[config.Path] = result
+
+	return result, nil
+}
+
+func (traces *traceFile) open() error {
+	file, err := os.OpenFile(traces.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("unable to open trace file %q: %w", traces.path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+
+		return fmt.Errorf("unable to stat trace file %q: %w", traces.path, err)
+	}
+
+	traces.file = file
+	traces.size = info.Size()
+
+	return nil
+}
+
+// write a line, first moving the file to path.1 when the line would grow it beyond maxSize.
+func (traces *traceFile) write(line []byte) error {
+	traces.lock.Lock()
+	defer traces.lock.Unlock()
+
+	if traces.size > 0 && traces.size+int64(len(line)) > traces.maxSize {
+		if err := traces.file.Close(); err != nil {
+			return fmt.Errorf("unable to close trace file %q: %w", traces.path, err)
+		}
+
+		if err := os.Rename(traces.path, traces.path+".1"); err != nil {
+			return fmt.Errorf("unable to rotate trace file %q: %w", traces.path, err)
+		}
+
+		if err := traces.open(); err != nil {
+			return err
+		}
+	}
+
+	written, err := traces.file.Write(line)
+	traces.size += int64(written)
+
+	return err
+}
+
+// Export write spans as a single line.
+func (exporter *FileExporter) Export(spans []*Span) error {
+	data, err := json.Marshal(otlpRequest(exporter.serviceName, spans))
+	if err != nil {
+		return err
+	}
+
+	return exporter.file.write(append(data, '\n'))
+}
+
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpValue an AnyValue. 64 bit integers are strings as in the protobuf JSON mapping.
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+func otlpRequest(serviceName string, spans []*Span) otlpExportRequest {
+	result := otlpScopeSpans{
+		Scope: otlpScope{Name: scopeName},
+		Spans: make([]otlpSpan, 0, len(spans)),
+	}
+
+	for _, span := range spans {
+		converted := otlpSpan{
+			TraceID:           hex.EncodeToString(span.Context.TraceID[:]),
+			SpanID:            hex.EncodeToString(span.Context.SpanID[:]),
+			Name:              span.Name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+		}
+
+		if span.Parent != [8]byte{} {
+			converted.ParentSpanID = hex.EncodeToString(span.Parent[:])
+		}
+
+		result.Spans = append(result.Spans, converted)
+	}
+
+	return otlpExportRequest{
+		ResourceSpans: []otlpResourceSpans{
+			{
+				Resource:   otlpResource{Attributes: otlpAttributes(map[string]interface{}{"service.name": serviceName})},
+				ScopeSpans: []otlpScopeSpans{result},
+			},
+		},
+	}
+}
+
+// otlpAttributes convert attributes sorted by key. Unsupported types are formatted as strings.
+func otlpAttributes(attributes map[string]interface{}) []otlpAttribute {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	result := make([]otlpAttribute, 0, len(keys))
+
+	for _, key := range keys {
+		var value otlpValue
+
+		switch typed := attributes[key].(type) {
+		case string:
+			value.StringValue = &typed
+		case bool:
+			value.BoolValue = &typed
+		case int:
+			formatted := strconv.Itoa(typed)
+			value.IntValue = &formatted
+		case int64:
+			formatted := strconv.FormatInt(typed, 10)
+			value.IntValue = &formatted
+		default:
+			formatted := fmt.Sprint(typed)
+			value.StringValue = &formatted
+		}
+
+		result = append(result, otlpAttribute{Key: key, Value: value})
+	}
+
+	return result
+}
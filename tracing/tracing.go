@@ -0,0 +1,267 @@
+// Package tracing records spans for the rewrite pipeline linked to W3C trace context.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// TraceparentHeader carries the W3C trace context.
+	TraceparentHeader = "traceparent"
+
+	// FlagSampled marks a trace the caller is recording.
+	FlagSampled byte = 0x01
+
+	traceparentVersion = "00"
+)
+
+// Config holds the tracing configuration.
+type Config struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// Exporter name of a registered exporter. Defaults to file.
+	Exporter string `json:"exporter,omitempty" yaml:"exporter,omitempty" toml:"exporter,omitempty"`
+	// Path of the file written by the file exporter.
+	Path string `json:"path,omitempty" yaml:"path,omitempty" toml:"path,omitempty"`
+	// ServiceName reported as the service.name resource attribute. Defaults to rewrite-body.
+	ServiceName string `json:"serviceName,omitempty" yaml:"serviceName,omitempty" toml:"serviceName,omitempty"`
+	// SampleRate records 1 in SampleRate requests without a valid traceparent. Defaults to 1, every request.
+	SampleRate int `json:"sampleRate,omitempty" yaml:"sampleRate,omitempty" toml:"sampleRate,omitempty"`
+	// MaxSize in bytes the file exporter writes before moving the file to path.1. Defaults to 10MiB.
+	MaxSize int64 `json:"maxSize,omitempty" yaml:"maxSize,omitempty" toml:"maxSize,omitempty"`
+}
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+// ParseTraceparent read a version 00 traceparent header value.
+func ParseTraceparent(value string) (SpanContext, bool) {
+	var result SpanContext
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return result, false
+	}
+
+	// Only version 00 is known. Later versions may only append fields.
+	if parts[0] == traceparentVersion && len(parts) != 4 {
+		return result, false
+	}
+
+	if !decodeHex(result.TraceID[:], parts[1]) || !decodeHex(result.SpanID[:], parts[2]) {
+		return result, false
+	}
+
+	var flags [1]byte
+	if !decodeHex(flags[:], parts[3]) {
+		return result, false
+	}
+
+	result.Flags = flags[0]
+
+	if result.TraceID == [16]byte{} || result.SpanID == [8]byte{} {
+		return result, false
+	}
+
+	return result, true
+}
+
+// Traceparent format the span context as a traceparent header value.
+func (spanContext SpanContext) Traceparent() string {
+	return fmt.Sprintf(
+		"%s-%s-%s-%02x",
+		traceparentVersion,
+		hex.EncodeToString(spanContext.TraceID[:]),
+		hex.EncodeToString(spanContext.SpanID[:]),
+		spanContext.Flags,
+	)
+}
+
+// Sampled report if the trace is recorded.
+func (spanContext SpanContext) Sampled() bool {
+	return spanContext.Flags&FlagSampled != 0
+}
+
+func decodeHex(target []byte, value string) bool {
+	if len(value) != 2*len(target) || strings.ToLower(value) != value {
+		return false
+	}
+
+	_, err := hex.Decode(target, []byte(value))
+
+	return err == nil
+}
+
+// Span a timed operation within a trace.
+type Span struct {
+	Name       string
+	Context    SpanContext
+	Parent     [8]byte
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+
+	trace *trace
+}
+
+// trace collects the spans of a single request until the root span ends.
+type trace struct {
+	lock     sync.Mutex
+	tracer   *Tracer
+	root     *Span
+	finished []*Span
+}
+
+// Tracer starts spans and hands finished traces to an Exporter.
+type Tracer struct {
+	exporter   Exporter
+	sampleRate uint64
+	requests   uint64
+}
+
+// NewTracer create a Tracer from config, returning nil when disabled.
+func NewTracer(config Config) (*Tracer, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	if config.SampleRate < 0 {
+		return nil, fmt.Errorf("tracing sampleRate must not be negative")
+	}
+
+	exporter, err := NewExporter(config)
+	if err != nil {
+		return nil, err
+	}
+
+	result := NewTracerWithExporter(exporter)
+	if config.SampleRate > 0 {
+		result.sampleRate = uint64(config.SampleRate)
+	}
+
+	return result, nil
+}
+
+// NewTracerWithExporter create a Tracer handing every trace to exporter.
+func NewTracerWithExporter(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter, sampleRate: 1}
+}
+
+// sampleNew determine if a trace started without a traceparent is recorded.
+func (tracer *Tracer) sampleNew() bool {
+	return atomic.AddUint64(&tracer.requests, 1)%tracer.sampleRate == 0
+}
+
+// StartTrace start the root span of a request as a child of the incoming traceparent when valid.
+// A nil Tracer returns a nil Span, on which every method is a no-op.
+func (tracer *Tracer) StartTrace(name, traceparent string) *Span {
+	if tracer == nil {
+		return nil
+	}
+
+	parent, ok := ParseTraceparent(traceparent)
+	if !ok {
+		parent = SpanContext{}
+		if tracer.sampleNew() {
+			parent.Flags = FlagSampled
+		}
+
+		randomID(parent.TraceID[:])
+	}
+
+	span := &Span{
+		Name:       name,
+		Context:    SpanContext{TraceID: parent.TraceID, Flags: parent.Flags},
+		Parent:     parent.SpanID,
+		Start:      time.Now(),
+		Attributes: make(map[string]interface{}),
+	}
+
+	randomID(span.Context.SpanID[:])
+
+	span.trace = &trace{tracer: tracer, root: span}
+
+	return span
+}
+
+// StartChild start a span nested in span.
+func (span *Span) StartChild(name string) *Span {
+	if span == nil {
+		return nil
+	}
+
+	child := &Span{
+		Name:       name,
+		Context:    SpanContext{TraceID: span.Context.TraceID, Flags: span.Context.Flags},
+		Parent:     span.Context.SpanID,
+		Start:      time.Now(),
+		Attributes: make(map[string]interface{}),
+		trace:      span.trace,
+	}
+
+	randomID(child.Context.SpanID[:])
+
+	return child
+}
+
+// SetAttribute record a string, bool or integer attribute on the span.
+func (span *Span) SetAttribute(key string, value interface{}) {
+	if span == nil {
+		return
+	}
+
+	span.Attributes[key] = value
+}
+
+// Traceparent the header value propagating span to downstream services.
+func (span *Span) Traceparent() string {
+	if span == nil {
+		return ""
+	}
+
+	return span.Context.Traceparent()
+}
+
+// Finish end the span. Ending the root span exports the trace when it is sampled.
+func (span *Span) Finish() error {
+	if span == nil {
+		return nil
+	}
+
+	span.End = time.Now()
+
+	current := span.trace
+	current.lock.Lock()
+	current.finished = append(current.finished, span)
+	spans := current.finished
+	current.lock.Unlock()
+
+	if span != current.root || !span.Context.Sampled() {
+		return nil
+	}
+
+	return current.tracer.exporter.Export(spans)
+}
+
+func randomID(target []byte) {
+	for {
+		if _, err := rand.Read(target); err != nil {
+			panic(fmt.Errorf("unable to generate trace id: %w", err))
+		}
+
+		for _, value := range target {
+			if value != 0 {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		desc       string
+		value      string
+		expOk      bool
+		expSampled bool
+	}{
+		{
+			desc:       "should parse a sampled traceparent",
+			value:      "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			expOk:      true,
+			expSampled: true,
+		},
+		{
+			desc:  "should parse an unsampled traceparent",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			expOk: true,
+		},
+		{
+			desc:       "should accept later versions with extra fields",
+			value:      "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			expOk:      true,
+			expSampled: true,
+		},
+		{
+			desc:  "should reject extra fields for version 00",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		},
+		{
+			desc:  "should reject version ff",
+			value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			desc:  "should reject an all zero trace id",
+			value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		},
+		{
+			desc:  "should reject uppercase hex",
+			value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		},
+		{
+			desc:  "should reject a short span id",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa-01",
+		},
+		{
+			desc: "should reject an empty value",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			spanContext, ok := ParseTraceparent(test.value)
+			if ok != test.expOk {
+				t.Fatalf("got ok %v, want %v", ok, test.expOk)
+			}
+
+			if ok && spanContext.Sampled() != test.expSampled {
+				t.Errorf("got sampled %v, want %v", spanContext.Sampled(), test.expSampled)
+			}
+
+			if ok && test.value[:2] == traceparentVersion && spanContext.Traceparent() != test.value {
+				t.Errorf("got %q, want %q", spanContext.Traceparent(), test.value)
+			}
+		})
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+
+	tracer, err := NewTracer(Config{Enabled: true, Path: path, ServiceName: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := tracer.StartTrace("request", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	child := root.StartChild("decode")
+	child.SetAttribute("bytes", 42)
+	child.SetAttribute("encoding", "gzip")
+
+	if err := child.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := root.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	unsampled := tracer.StartTrace("request", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	if err := unsampled.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := bytes.Count(data, []byte("\n")); lines != 1 {
+		t.Fatalf("got %d exported traces, want 1", lines)
+	}
+
+	var request otlpExportRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+
+	decode, root0 := spans[0], spans[1]
+
+	if root0.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || root0.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("root span not linked to the incoming trace: %+v", root0)
+	}
+
+	if decode.ParentSpanID != root0.SpanID {
+		t.Errorf("got child parent %q, want %q", decode.ParentSpanID, root0.SpanID)
+	}
+
+	if len(decode.Attributes) != 2 || *decode.Attributes[0].Value.IntValue != "42" {
+		t.Errorf("got attributes %+v", decode.Attributes)
+	}
+
+	if *request.ResourceSpans[0].Resource.Attributes[0].Value.StringValue != "test" {
+		t.Errorf("got resource %+v", request.ResourceSpans[0].Resource)
+	}
+}
+
+func TestUnknownExporter(t *testing.T) {
+	if _, err := NewTracer(Config{Enabled: true, Exporter: "missing"}); err == nil {
+		t.Error("expected an error for an unknown exporter")
+	}
+}
+
+func TestFileExporterSharing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+
+	first, err := newFileExporter(Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := newFileExporter(Config{Path: path, ServiceName: "reloaded"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.(*FileExporter).file != second.(*FileExporter).file {
+		t.Error("reloading opened a second handle for the same path")
+	}
+}
+
+func TestFileExporterLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+
+	tracer, err := NewTracer(Config{Enabled: true, Path: path, SampleRate: 2, MaxSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := tracer.StartTrace("request", "").Finish(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := os.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := bytes.Count(current, []byte("\n")) + bytes.Count(rotated, []byte("\n")); lines != 2 {
+		t.Errorf("got %d traces across the files, want 2", lines)
+	}
+
+	if lines := bytes.Count(current, []byte("\n")); lines != 1 {
+		t.Errorf("got %d traces after rotation, want 1", lines)
+	}
+
+	if _, err := NewTracer(Config{Enabled: true, Path: path, SampleRate: -1}); err == nil {
+		t.Error("expected an error for a negative sampleRate")
+	}
+}